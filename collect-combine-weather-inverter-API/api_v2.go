@@ -0,0 +1,191 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+// The v2 API uses snake_case keys throughout, puts the unit in the key of
+// every measured quantity and reports unknown times as null rather than the
+// zero time.
+
+type SnapshotV2 struct {
+	StationID         string       `json:"station_id" doc:"SEMS power station ID."`
+	StationName       string       `json:"station_name"`
+	CollectedAt       time.Time    `json:"collected_at" doc:"When the service polled SEMS, UTC."`
+	Timezone          string       `json:"timezone" doc:"Zone used for the station's local day, an IANA name or a fixed offset."`
+	CapacityKW        float64      `json:"capacity_kw" unit:"kW" doc:"Installed capacity of the station."`
+	BatterySOCPercent *float64     `json:"battery_soc_percent" unit:"%" doc:"State of charge of the station battery. Null without one."`
+	Inverters         []InverterV2 `json:"inverters"`
+	Weather           WeatherV2    `json:"weather"`
+}
+
+type InverterV2 struct {
+	SerialNumber           string     `json:"serial_number"`
+	Name                   string     `json:"name"`
+	CapacityKW             float64    `json:"capacity_kw" unit:"kW"`
+	OutputPowerW           float64    `json:"output_power_w" unit:"W" doc:"Current AC output."`
+	EnergyTodayKWh         float64    `json:"energy_today_kwh" unit:"kWh" doc:"Generation so far in the station's local day."`
+	EnergyMonthKWh         float64    `json:"energy_month_kwh" unit:"kWh"`
+	EnergyTotalKWh         float64    `json:"energy_total_kwh" unit:"kWh" doc:"Lifetime generation."`
+	ReadTime               time.Time  `json:"read_time" doc:"When SEMS last heard from the inverter, UTC."`
+	BootTime               *time.Time `json:"boot_time" doc:"When the inverter last came online, UTC. Null when unknown."`
+	Status                 int        `json:"status" doc:"SEMS status code: -1 offline, 0 waiting, 1 generating, 2 fault."`
+	ClearSkyOutputW        float64    `json:"clear_sky_output_w" unit:"W" doc:"Modelled output under a clear sky."`
+	PerformanceRatio       float64    `json:"performance_ratio" unit:"1" doc:"Output divided by the output expected from the available irradiance, 0 to about 1."`
+	SpecificYieldKWhPerKWp float64    `json:"specific_yield_kwh_per_kwp" unit:"kWh/kWp" doc:"Energy since the inverter's previous SEMS reading per kW of capacity. 0 for the first reading seen."`
+	WorkHours              float64    `json:"work_hours" unit:"h"`
+	PVVoltageV             []float64  `json:"pv_voltage_v" unit:"V" doc:"Per MPPT string."`
+	PVCurrentA             []float64  `json:"pv_current_a" unit:"A" doc:"Per MPPT string."`
+	ACVoltageV             []float64  `json:"ac_voltage_v" unit:"V" doc:"Per phase."`
+	ACCurrentA             []float64  `json:"ac_current_a" unit:"A" doc:"Per phase."`
+	ACFrequencyHz          []float64  `json:"ac_frequency_hz" unit:"Hz" doc:"Per phase."`
+	TemperatureC           *float64   `json:"temperature_c" unit:"°C" doc:"Internal temperature of the inverter. Null when SEMS does not report it or the inverter is offline."`
+}
+
+type WeatherV2 struct {
+	Provider          string     `json:"provider" doc:"openweathermap, openmeteo, bom or metno."`
+	ObservedAt        time.Time  `json:"observed_at" doc:"Time of the observation, UTC."`
+	TemperatureC      float64    `json:"temperature_c" unit:"°C"`
+	HumidityPercent   float64    `json:"humidity_percent" unit:"%"`
+	PressureHPa       float64    `json:"pressure_hpa" unit:"hPa"`
+	WindSpeedMS       float64    `json:"wind_speed_m_s" unit:"m/s"`
+	WindDirectionDeg  float64    `json:"wind_direction_deg" unit:"°" doc:"Direction the wind comes from, clockwise from north."`
+	CloudCoverPercent int        `json:"cloud_cover_percent" unit:"%"`
+	Condition         string     `json:"condition" doc:"Short condition, e.g. Clouds."`
+	Description       string     `json:"description"`
+	Sunrise           *time.Time `json:"sunrise" doc:"UTC."`
+	Sunset            *time.Time `json:"sunset" doc:"UTC."`
+	GHIWM2            float64    `json:"ghi_w_m2" unit:"W/m²" doc:"Global horizontal irradiance. 0 when the provider has none."`
+	DNIWM2            float64    `json:"dni_w_m2" unit:"W/m²" doc:"Direct normal irradiance."`
+	DHIWM2            float64    `json:"dhi_w_m2" unit:"W/m²" doc:"Diffuse horizontal irradiance."`
+}
+
+type DailySummaryV2 struct {
+	StationID              string     `json:"station_id"`
+	Date                   string     `json:"date" doc:"Local day of the station, YYYY-MM-DD."`
+	CapacityKW             float64    `json:"capacity_kw" unit:"kW"`
+	EnergyKWh              float64    `json:"energy_kwh" unit:"kWh" doc:"Generation so far today."`
+	ClearSkyEnergyKWh      float64    `json:"clear_sky_energy_kwh" unit:"kWh" doc:"Modelled generation for the whole day under a clear sky."`
+	PerformanceRatio       float64    `json:"performance_ratio" unit:"1" doc:"energy_kwh divided by clear_sky_energy_kwh."`
+	SpecificYieldKWhPerKWp float64    `json:"specific_yield_kwh_per_kwp" unit:"kWh/kWp"`
+	Sunrise                *time.Time `json:"sunrise" doc:"UTC."`
+	Sunset                 *time.Time `json:"sunset" doc:"UTC."`
+}
+
+type ErrorV2 struct {
+	Error string `json:"error"`
+}
+
+func optionalTime(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
+
+func snapshotV2(snapshot Snapshot) SnapshotV2 {
+	v2 := SnapshotV2{
+		StationID:   snapshot.StationID,
+		StationName: snapshot.InverterData.Data.Info.Stationname,
+		CollectedAt: snapshot.CollectedAt,
+		CapacityKW:  stationCapacity(snapshot.InverterData),
+		Inverters:   []InverterV2{},
+		Weather:     weatherV2(snapshot.Weather),
+	}
+	if snapshot.Location != nil {
+		v2.Timezone = snapshot.Location.String()
+	}
+	if info := snapshot.InverterData.Data.Info; info.IsStored || info.BatteryCapacity > 0 {
+		soc := float64(snapshot.InverterData.Data.Soc.Power)
+		v2.BatterySOCPercent = &soc
+	}
+	for _, reading := range snapshot.Readings {
+		v2.Inverters = append(v2.Inverters, InverterV2{
+			SerialNumber:           reading.InverterSN,
+			Name:                   reading.InverterName,
+			CapacityKW:             reading.Capacity,
+			OutputPowerW:           reading.OutputPower,
+			EnergyTodayKWh:         reading.EnergyDay,
+			EnergyMonthKWh:         reading.EnergyMonth,
+			EnergyTotalKWh:         reading.EnergyTotal,
+			ReadTime:               reading.ReadTime,
+			BootTime:               optionalTime(reading.BootTime),
+			Status:                 reading.Status,
+			ClearSkyOutputW:        reading.Performance.ClearSkyOutput,
+			PerformanceRatio:       reading.Performance.PerformanceRatio,
+			SpecificYieldKWhPerKWp: reading.Performance.SpecificYield,
+			WorkHours:              reading.WorkHours,
+			PVVoltageV:             reading.Vpv[:],
+			PVCurrentA:             reading.Ipv[:],
+			ACVoltageV:             reading.Vac[:],
+			ACCurrentA:             reading.Iac[:],
+			ACFrequencyHz:          reading.Fac[:],
+			TemperatureC:           reading.Temperature,
+		})
+	}
+	return v2
+}
+
+func weatherV2(weather Weather) WeatherV2 {
+	return WeatherV2{
+		Provider:          weather.Provider,
+		ObservedAt:        unixToUTC(int(weather.ObservedAt)),
+		TemperatureC:      weather.Temperature,
+		HumidityPercent:   weather.Humidity,
+		PressureHPa:       weather.Pressure,
+		WindSpeedMS:       weather.WindSpeed,
+		WindDirectionDeg:  weather.WindDeg,
+		CloudCoverPercent: weather.CloudPercent,
+		Condition:         weather.Type,
+		Description:       weather.Description,
+		Sunrise:           optionalTime(unixToUTC(weather.Sunrise)),
+		Sunset:            optionalTime(unixToUTC(weather.Sunset)),
+		GHIWM2:            weather.GHI,
+		DNIWM2:            weather.DNI,
+		DHIWM2:            weather.DHI,
+	}
+}
+
+func dailySummaryV2(stationID string, summary DailySummary) DailySummaryV2 {
+	return DailySummaryV2{
+		StationID:              stationID,
+		Date:                   summary.Date,
+		CapacityKW:             summary.Capacity,
+		EnergyKWh:              summary.EnergyDay,
+		ClearSkyEnergyKWh:      summary.ClearSkyEnergy,
+		PerformanceRatio:       summary.PerformanceRatio,
+		SpecificYieldKWhPerKWp: summary.SpecificYield,
+		Sunrise:                optionalTime(unixToUTC(summary.Sunrise)),
+		Sunset:                 optionalTime(unixToUTC(summary.Sunset)),
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func snapshotV2Handler(configs *ConfigSource) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		snapshot, err := collectSnapshot(configs.Get())
+		if err != nil {
+			writeJSON(w, http.StatusBadGateway, ErrorV2{Error: err.Error()})
+			return
+		}
+		writeJSON(w, http.StatusOK, snapshotV2(snapshot))
+	}
+}
+
+func dailySummaryV2Handler(configs *ConfigSource) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		snapshot, err := collectSnapshot(configs.Get())
+		if err != nil {
+			writeJSON(w, http.StatusBadGateway, ErrorV2{Error: err.Error()})
+			return
+		}
+		writeJSON(w, http.StatusOK, dailySummaryV2(snapshot.StationID, snapshotDailySummary(snapshot)))
+	}
+}
@@ -0,0 +1,259 @@
+package main
+
+import (
+	"crypto/subtle"
+	"math"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Scopes granted to API keys and users. An empty scope list grants all.
+const (
+	// scopeRead covers stored data, exports and metrics.
+	scopeRead = "read"
+	// scopeLive covers routes that log in to SEMS on every request.
+	scopeLive = "live"
+)
+
+var knownScopes = []string{scopeRead, scopeLive}
+
+type AuthConfig struct {
+	APIKeys            []APIKeyConfig `json:"apiKeys" doc:"Keys accepted as 'Authorization: Bearer <key>' or 'X-API-Key: <key>'. Browsers cannot send them, so the dashboard needs one of users. No keys and no users: the API is open."`
+	Users              []UserConfig   `json:"users" doc:"HTTP basic auth users."`
+	RateLimitPerMinute float64        `json:"rateLimitPerMinute" doc:"Requests per minute per key, user or, when unauthenticated, client IP. 0: unlimited."`
+	RateLimitBurst     int            `json:"rateLimitBurst" doc:"Requests allowed at once before the rate applies. 0: rateLimitPerMinute / 6, at least 1."`
+}
+
+type APIKeyConfig struct {
+	Name    string   `json:"name" doc:"Identifies the key in logs and rate limits."`
+	Key     string   `json:"key"`
+	KeyFile string   `json:"keyFile" doc:"Read the key from this file instead, e.g. a mounted secret."`
+	Scopes  []string `json:"scopes" doc:"read, live. Empty: all."`
+}
+
+type UserConfig struct {
+	Username     string   `json:"username"`
+	Password     string   `json:"password"`
+	PasswordFile string   `json:"passwordFile" doc:"Read the password from this file instead."`
+	Scopes       []string `json:"scopes" doc:"read, live. Empty: all."`
+}
+
+func (auth AuthConfig) enabled() bool {
+	return len(auth.APIKeys) > 0 || len(auth.Users) > 0
+}
+
+// resolveSecrets reads keyFile and passwordFile once per config load so
+// requests never touch the filesystem. Unreadable files leave the secret
+// empty, which validate reports.
+func (auth *AuthConfig) resolveSecrets() {
+	for i := range auth.APIKeys {
+		if file := auth.APIKeys[i].KeyFile; file != "" {
+			auth.APIKeys[i].Key = readSecret(file)
+		}
+	}
+	for i := range auth.Users {
+		if file := auth.Users[i].PasswordFile; file != "" {
+			auth.Users[i].Password = readSecret(file)
+		}
+	}
+}
+
+func readSecret(file string) string {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimRight(string(data), "\r\n")
+}
+
+func (auth AuthConfig) validate() []string {
+	var problems []string
+	checkScopes := func(field string, scopes []string) {
+		for _, scope := range scopes {
+			if !containsString(knownScopes, scope) {
+				problems = append(problems, field+".scopes: unknown scope "+strconv.Quote(scope))
+			}
+		}
+	}
+	names := make(map[string]bool)
+	for i, key := range auth.APIKeys {
+		field := "auth.apiKeys[" + strconv.Itoa(i) + "]"
+		if key.Name == "" {
+			problems = append(problems, field+".name is required")
+		} else if names[key.Name] {
+			problems = append(problems, field+".name "+strconv.Quote(key.Name)+" is used twice")
+		}
+		names[key.Name] = true
+		if key.Key == "" {
+			problems = append(problems, field+": key is empty; set key or a readable, non-empty keyFile")
+		}
+		checkScopes(field, key.Scopes)
+	}
+	for i, user := range auth.Users {
+		field := "auth.users[" + strconv.Itoa(i) + "]"
+		if user.Username == "" {
+			problems = append(problems, field+".username is required")
+		} else if names[user.Username] {
+			problems = append(problems, field+".username "+strconv.Quote(user.Username)+" is used twice")
+		}
+		names[user.Username] = true
+		if user.Password == "" {
+			problems = append(problems, field+": password is empty; set password or a readable, non-empty passwordFile")
+		}
+		checkScopes(field, user.Scopes)
+	}
+	if auth.RateLimitPerMinute < 0 || auth.RateLimitBurst < 0 {
+		problems = append(problems, "auth rate limits must not be negative")
+	}
+	return problems
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func grants(scopes []string, scope string) bool {
+	return len(scopes) == 0 || containsString(scopes, scope)
+}
+
+// Authenticator checks credentials against the current config on every
+// request, so keys can be added or revoked with a config reload.
+type Authenticator struct {
+	configs *ConfigSource
+	limiter *rateLimiter
+}
+
+func newAuthenticator(configs *ConfigSource) *Authenticator {
+	return &Authenticator{configs: configs, limiter: newRateLimiter()}
+}
+
+// require wraps a handler so it only runs for clients holding scope.
+func (a *Authenticator) require(scope string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		auth := a.configs.Get().Auth
+		client := "ip:" + clientIP(r)
+		if auth.enabled() {
+			name, scopes, ok := authenticate(auth, r)
+			if !ok {
+				if !a.limiter.allow(client, auth) {
+					tooManyRequests(w, auth)
+					return
+				}
+				w.Header().Set("WWW-Authenticate", `Basic realm="solar", charset="UTF-8"`)
+				http.Error(w, "unauthorized", http.StatusUnauthorized)
+				return
+			}
+			client = name
+			if !grants(scopes, scope) {
+				http.Error(w, "forbidden: needs scope "+scope, http.StatusForbidden)
+				return
+			}
+		}
+		if !a.limiter.allow(client, auth) {
+			tooManyRequests(w, auth)
+			return
+		}
+		next(w, r)
+	}
+}
+
+func authenticate(auth AuthConfig, r *http.Request) (string, []string, bool) {
+	presented := r.Header.Get("X-API-Key")
+	if bearer, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+		presented = strings.TrimSpace(bearer)
+	}
+	if presented != "" {
+		for _, key := range auth.APIKeys {
+			if subtle.ConstantTimeCompare([]byte(presented), []byte(key.Key)) == 1 {
+				return "key:" + key.Name, key.Scopes, true
+			}
+		}
+		return "", nil, false
+	}
+	if username, password, ok := r.BasicAuth(); ok {
+		for _, user := range auth.Users {
+			if subtle.ConstantTimeCompare([]byte(username), []byte(user.Username)) == 1 &&
+				subtle.ConstantTimeCompare([]byte(password), []byte(user.Password)) == 1 {
+				return "user:" + user.Username, user.Scopes, true
+			}
+		}
+	}
+	return "", nil, false
+}
+
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+func tooManyRequests(w http.ResponseWriter, auth AuthConfig) {
+	retry := math.Ceil(60 / auth.RateLimitPerMinute)
+	w.Header().Set("Retry-After", strconv.Itoa(int(retry)))
+	http.Error(w, "too many requests", http.StatusTooManyRequests)
+}
+
+// rateLimiter is a token bucket per client. Buckets idle long enough to have
+// refilled are dropped.
+type rateLimiter struct {
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastPrune time.Time
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+func newRateLimiter() *rateLimiter {
+	return &rateLimiter{buckets: make(map[string]*bucket)}
+}
+
+func (l *rateLimiter) allow(client string, auth AuthConfig) bool {
+	if auth.RateLimitPerMinute <= 0 {
+		return true
+	}
+	burst := float64(auth.RateLimitBurst)
+	if burst <= 0 {
+		burst = math.Max(1, math.Floor(auth.RateLimitPerMinute/6))
+	}
+	perSecond := auth.RateLimitPerMinute / 60
+	now := time.Now()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if now.Sub(l.lastPrune) > time.Minute {
+		full := time.Duration(burst / perSecond * float64(time.Second))
+		for name, b := range l.buckets {
+			if now.Sub(b.last) > full {
+				delete(l.buckets, name)
+			}
+		}
+		l.lastPrune = now
+	}
+	b, ok := l.buckets[client]
+	if !ok {
+		b = &bucket{tokens: burst, last: now}
+		l.buckets[client] = b
+	}
+	b.tokens = math.Min(burst, b.tokens+now.Sub(b.last).Seconds()*perSecond)
+	b.last = now
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+const dateLayout = "2006-01-02"
+
+// PowerPoint is one point of a SEMS day power curve, in W.
+type PowerPoint struct {
+	Time  time.Time
+	Power float64
+}
+
+// DailyEnergy is the generation of a station for one local day, in kWh.
+type DailyEnergy struct {
+	Day    time.Time
+	Energy float64
+}
+
+type ChartResponse struct {
+	HasError bool   `json:"hasError"`
+	Msg      string `json:"msg"`
+	Data     struct {
+		Lines []struct {
+			Key  string `json:"key"`
+			Name string `json:"name"`
+			Unit string `json:"unit"`
+			XY   []struct {
+				X string   `json:"x"`
+				Y *float64 `json:"y"`
+			} `json:"xy"`
+		} `json:"lines"`
+	} `json:"data"`
+}
+
+func runBackfill(args []string) {
+	flags := flag.NewFlagSet("backfill", flag.ExitOnError)
+	from := flags.String("from", "", "first local day to recover, YYYY-MM-DD")
+	to := flags.String("to", time.Now().Format(dateLayout), "last local day to recover, YYYY-MM-DD")
+	flags.Parse(args)
+
+	config := importConfig()
+	if config.Database.DSN == "" {
+		log.Fatal("backfill: database.dsn is not configured")
+	}
+	fromDay, err := time.Parse(dateLayout, *from)
+	if err != nil {
+		log.Fatalf("backfill: --from: %v", err)
+	}
+	toDay, err := time.Parse(dateLayout, *to)
+	if err != nil {
+		log.Fatalf("backfill: --to: %v", err)
+	}
+	if toDay.Before(fromDay) {
+		log.Fatal("backfill: --to is before --from")
+	}
+	store, err := openStore(config.Database.DSN)
+	if err != nil {
+		log.Fatalf("backfill: %v", err)
+	}
+	defer store.Close()
+	if err := backfill(config, store, fromDay, toDay); err != nil {
+		log.Fatalf("backfill: %v", err)
+	}
+}
+
+func backfill(config Config, store *Store, fromDay time.Time, toDay time.Time) error {
+	loginResponse, err := runLoginRequest(config)
+	if err != nil {
+		return err
+	}
+	inverterData, err := getInverterData(config, loginResponse)
+	if err != nil {
+		return err
+	}
+	loc, err := stationLocation(config, Weather{}, inverterData, time.Now())
+	if err != nil {
+		return err
+	}
+	stationID := config.ClientConfig.StationInfo.StationID
+
+	for day := fromDay; !day.After(toDay); day = day.AddDate(0, 0, 1) {
+		points, err := getPowerCurve(config, loginResponse, day, loc)
+		if err != nil {
+			return fmt.Errorf("%s: %v", day.Format(dateLayout), err)
+		}
+		inserted, err := store.saveBackfilledPower(stationID, points)
+		if err != nil {
+			return err
+		}
+		logger.Info("backfill power curve", "day", day.Format(dateLayout), "inserted", inserted, "points", len(points))
+		time.Sleep(time.Second)
+	}
+
+	for month := time.Date(fromDay.Year(), fromDay.Month(), 1, 0, 0, 0, 0, time.UTC); !month.After(toDay); month = month.AddDate(0, 1, 0) {
+		days, err := getMonthEnergy(config, loginResponse, month)
+		if err != nil {
+			return fmt.Errorf("%s: %v", month.Format("2006-01"), err)
+		}
+		var inRange []DailyEnergy
+		for _, d := range days {
+			if !d.Day.Before(fromDay) && !d.Day.After(toDay) {
+				inRange = append(inRange, d)
+			}
+		}
+		if err := store.saveDailyEnergy(stationID, inRange, "backfill"); err != nil {
+			return err
+		}
+		logger.Info("backfill daily energy", "month", month.Format("2006-01"), "days", len(inRange))
+		time.Sleep(time.Second)
+	}
+	return nil
+}
+
+func getPowerCurve(config Config, loginResponse LoginResponse, day time.Time, loc *time.Location) ([]PowerPoint, error) {
+	postData := map[string]interface{}{
+		"id":          config.ClientConfig.StationInfo.StationID,
+		"date":        day.Format(dateLayout),
+		"full_script": false,
+	}
+	var chart ChartResponse
+	chartURL := orDefault(config.APIConfig.PowerChartURL, "/api/v2/Charts/GetPlantPowerChart")
+	if err := postSEMS(config.APIConfig.BaseURL+chartURL, loginResponse.Data, postData, &chart); err != nil {
+		return nil, err
+	}
+	if chart.HasError {
+		return nil, fmt.Errorf("SEMS power chart: %s", chart.Msg)
+	}
+	var points []PowerPoint
+	for _, line := range chart.Data.Lines {
+		if !isPVLine(line.Key, line.Name) {
+			continue
+		}
+		for _, xy := range line.XY {
+			t, err := time.ParseInLocation(dateLayout+" 15:04", day.Format(dateLayout)+" "+xy.X, loc)
+			if err != nil || xy.Y == nil {
+				continue
+			}
+			points = append(points, PowerPoint{Time: t.UTC(), Power: *xy.Y})
+		}
+		break
+	}
+	return points, nil
+}
+
+func getMonthEnergy(config Config, loginResponse LoginResponse, month time.Time) ([]DailyEnergy, error) {
+	postData := map[string]interface{}{
+		"id":           config.ClientConfig.StationInfo.StationID,
+		"date":         month.Format(dateLayout),
+		"range":        2,
+		"chartIndexId": "8",
+		"isDetailFull": false,
+	}
+	var chart ChartResponse
+	chartURL := orDefault(config.APIConfig.EnergyChartURL, "/api/v2/Charts/GetChartByPlant")
+	if err := postSEMS(config.APIConfig.BaseURL+chartURL, loginResponse.Data, postData, &chart); err != nil {
+		return nil, err
+	}
+	if chart.HasError {
+		return nil, fmt.Errorf("SEMS energy chart: %s", chart.Msg)
+	}
+	var days []DailyEnergy
+	for _, line := range chart.Data.Lines {
+		if !isPVLine(line.Key, line.Name) {
+			continue
+		}
+		for _, xy := range line.XY {
+			day, err := time.Parse(dateLayout, xy.X)
+			if err != nil || xy.Y == nil {
+				continue
+			}
+			days = append(days, DailyEnergy{Day: day, Energy: *xy.Y})
+		}
+		break
+	}
+	return days, nil
+}
+
+func isPVLine(key string, name string) bool {
+	key = strings.ToLower(key)
+	return strings.Contains(key, "pv") || strings.HasPrefix(strings.ToLower(name), "pv")
+}
+
+func orDefault(value string, fallback string) string {
+	if value == "" {
+		return fallback
+	}
+	return value
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"math"
+	"net/http"
+	"sort"
+	"time"
+)
+
+type BillV2 struct {
+	StationID string      `json:"station_id"`
+	From      string      `json:"from" doc:"First day of the period, YYYY-MM-DD local time."`
+	To        string      `json:"to" doc:"Last day of the period, inclusive."`
+	Currency  string      `json:"currency"`
+	Totals    BillCostsV2 `json:"totals"`
+	Days      []BillDayV2 `json:"days"`
+}
+
+type BillDayV2 struct {
+	Date  string      `json:"date" doc:"Local day, YYYY-MM-DD."`
+	Costs BillCostsV2 `json:"costs"`
+}
+
+// BillCostsV2 is the cost of a bill or of one of its days, in the tariff's
+// currency. Import and self-consumption are broken down by time-of-use
+// period; the time outside them is the period "other".
+type BillCostsV2 struct {
+	SupplyDays         int                 `json:"supply_days" doc:"Days charged the daily supply charge."`
+	SupplyCharge       float64             `json:"supply_charge"`
+	Periods            []BillPeriodCostsV2 `json:"periods"`
+	ImportKWh          float64             `json:"import_kwh" unit:"kWh"`
+	ImportCost         float64             `json:"import_cost"`
+	ExportKWh          float64             `json:"export_kwh" unit:"kWh"`
+	ExportCredit       float64             `json:"export_credit" doc:"Subtracted from the bill."`
+	SelfConsumptionKWh float64             `json:"self_consumption_kwh" unit:"kWh"`
+	AvoidedImportCost  float64             `json:"avoided_import_cost" doc:"What the self-consumed energy would have cost to import. Not part of the bill."`
+	Subtotal           float64             `json:"subtotal" doc:"supply_charge plus import_cost less export_credit, before tax."`
+	Tax                float64             `json:"tax"`
+	Total              float64             `json:"total" doc:"What the retailer should bill."`
+	Metered            bool                `json:"metered" doc:"Whether import and export were measured throughout. When false, energy during unmeasured intervals is missing from the bill."`
+}
+
+type BillPeriodCostsV2 struct {
+	Name               string  `json:"name" doc:"tariff.timeOfUse name, or other."`
+	ImportKWh          float64 `json:"import_kwh" unit:"kWh"`
+	ImportCost         float64 `json:"import_cost"`
+	SelfConsumptionKWh float64 `json:"self_consumption_kwh" unit:"kWh"`
+	AvoidedImportCost  float64 `json:"avoided_import_cost"`
+}
+
+// billCalculator prices energy intervals with a tariff, keeping a running
+// cost per local day.
+type billCalculator struct {
+	tariff TariffConfig
+	days   map[string]*BillDayV2
+}
+
+func newBillCalculator(tariff TariffConfig) *billCalculator {
+	return &billCalculator{tariff: tariff, days: make(map[string]*BillDayV2)}
+}
+
+func (c *billCalculator) day(date string) *BillDayV2 {
+	if c.days[date] == nil {
+		c.days[date] = &BillDayV2{Date: date, Costs: BillCostsV2{Metered: true, Periods: []BillPeriodCostsV2{}}}
+	}
+	return c.days[date]
+}
+
+// add prices an interval on the day its midpoint falls on.
+func (c *billCalculator) add(interval energyInterval) {
+	mid := interval.Mid()
+	costs := &c.day(mid.Format(dateLayout)).Costs
+	if !interval.Metered {
+		costs.Metered = false
+		return
+	}
+	rate, name := c.tariff.importRate(mid)
+	costs.addPeriod(BillPeriodCostsV2{
+		Name:               name,
+		ImportKWh:          interval.Import,
+		ImportCost:         interval.Import * rate,
+		SelfConsumptionKWh: interval.SelfConsumption,
+		AvoidedImportCost:  interval.SelfConsumption * rate,
+	})
+	costs.ExportKWh += interval.Export
+	costs.ExportCredit += interval.Export * c.tariff.FeedInRate
+}
+
+func (b *BillCostsV2) addPeriod(p BillPeriodCostsV2) {
+	b.ImportKWh += p.ImportKWh
+	b.ImportCost += p.ImportCost
+	b.SelfConsumptionKWh += p.SelfConsumptionKWh
+	b.AvoidedImportCost += p.AvoidedImportCost
+	for i := range b.Periods {
+		if b.Periods[i].Name == p.Name {
+			b.Periods[i].ImportKWh += p.ImportKWh
+			b.Periods[i].ImportCost += p.ImportCost
+			b.Periods[i].SelfConsumptionKWh += p.SelfConsumptionKWh
+			b.Periods[i].AvoidedImportCost += p.AvoidedImportCost
+			return
+		}
+	}
+	b.Periods = append(b.Periods, p)
+}
+
+// total charges the supply and works out the subtotal, tax and total.
+// Amounts are rounded to cents as a retailer would.
+func (b *BillCostsV2) total(tariff TariffConfig) {
+	b.SupplyCharge = float64(b.SupplyDays) * tariff.DailySupplyCharge
+	b.Subtotal = roundCents(b.SupplyCharge + b.ImportCost - b.ExportCredit)
+	b.Tax = roundCents(b.Subtotal * tariff.TaxPercent / 100)
+	b.Total = roundCents(b.Subtotal + b.Tax)
+	sort.Slice(b.Periods, func(i, j int) bool { return b.Periods[i].Name < b.Periods[j].Name })
+}
+
+func roundCents(amount float64) float64 {
+	return math.Round(amount*100) / 100
+}
+
+// bill covers every local day from first to last, charging supply for each
+// even when no readings were stored that day.
+func (c *billCalculator) bill(stationID string, first time.Time, last time.Time) BillV2 {
+	bill := BillV2{
+		StationID: stationID,
+		From:      first.Format(dateLayout),
+		To:        last.Format(dateLayout),
+		Currency:  c.tariff.Currency,
+		Totals:    BillCostsV2{Metered: true, Periods: []BillPeriodCostsV2{}},
+		Days:      []BillDayV2{},
+	}
+	totals := &bill.Totals
+	for day := first; !day.After(last); day = day.AddDate(0, 0, 1) {
+		d := c.day(day.Format(dateLayout))
+		d.Costs.SupplyDays = 1
+		d.Costs.total(c.tariff)
+		totals.SupplyDays++
+		for _, p := range d.Costs.Periods {
+			totals.addPeriod(p)
+		}
+		totals.ExportKWh += d.Costs.ExportKWh
+		totals.ExportCredit += d.Costs.ExportCredit
+		totals.Metered = totals.Metered && d.Costs.Metered
+		bill.Days = append(bill.Days, *d)
+	}
+	totals.total(c.tariff)
+	return bill
+}
+
+func calculateBill(store *Store, config Config, stationID string, first time.Time, last time.Time) (BillV2, error) {
+	loc := reportLocation(config)
+	// As for monthly reports, read a margin and keep the intervals whose
+	// midpoint falls in the period.
+	from := time.Date(first.Year(), first.Month(), first.Day(), 0, 0, 0, 0, time.UTC)
+	to := time.Date(last.Year(), last.Month(), last.Day()+1, 0, 0, 0, 0, time.UTC)
+	readings, err := store.stationReadings(stationID, from.Add(-48*time.Hour), to.Add(48*time.Hour))
+	if err != nil {
+		return BillV2{}, err
+	}
+	calculator := newBillCalculator(config.Tariff)
+	firstDate, lastDate := first.Format(dateLayout), last.Format(dateLayout)
+	for _, interval := range energyIntervals(readings, loc) {
+		if date := interval.Mid().Format(dateLayout); date >= firstDate && date <= lastDate {
+			calculator.add(interval)
+		}
+	}
+	return calculator.bill(stationID, first, last), nil
+}
+
+func billHandler(configs *ConfigSource, store *Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if store == nil {
+			writeJSON(w, http.StatusServiceUnavailable, ErrorV2{Error: "bills need database.dsn to be configured"})
+			return
+		}
+		config := configs.Get()
+		query := r.URL.Query()
+		first, last := config.Tariff.billingPeriod(time.Now().In(reportLocation(config)))
+		if query.Get("from") != "" || query.Get("to") != "" {
+			var err error
+			if first, err = time.Parse(dateLayout, query.Get("from")); err != nil {
+				writeJSON(w, http.StatusBadRequest, ErrorV2{Error: "from must be YYYY-MM-DD"})
+				return
+			}
+			if last, err = time.Parse(dateLayout, query.Get("to")); err != nil {
+				writeJSON(w, http.StatusBadRequest, ErrorV2{Error: "to must be YYYY-MM-DD"})
+				return
+			}
+		}
+		if last.Before(first) || last.Sub(first) > 400*24*time.Hour {
+			writeJSON(w, http.StatusBadRequest, ErrorV2{Error: "to must be on or after from and at most 400 days later"})
+			return
+		}
+		stationID := orDefault(query.Get("station"), config.ClientConfig.StationInfo.StationID)
+		bill, err := calculateBill(store, config, stationID, first, last)
+		if err != nil {
+			writeJSON(w, http.StatusInternalServerError, ErrorV2{Error: err.Error()})
+			return
+		}
+		writeJSON(w, http.StatusOK, bill)
+	}
+}
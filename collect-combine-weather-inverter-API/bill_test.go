@@ -0,0 +1,81 @@
+package main
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestBillCalculator(t *testing.T) {
+	tariff := TariffConfig{
+		Currency:          "AUD",
+		ImportRate:        0.2345,
+		FeedInRate:        0.05,
+		DailySupplyCharge: 1,
+		TaxPercent:        10,
+		TimeOfUse:         []TimeOfUseRate{{Name: "peak", From: "15:00", To: "21:00", Days: []string{"weekdays"}, Rate: 0.50}},
+	}
+	at := func(day int, hour int) time.Time { return time.Date(2026, 3, day, hour, 0, 0, 0, time.UTC) }
+	interval := func(start time.Time, imported, exported, self float64) energyInterval {
+		return energyInterval{Start: start, End: start.Add(10 * time.Minute), Import: imported, Export: exported,
+			SelfConsumption: self, Metered: true}
+	}
+	c := newBillCalculator(tariff)
+	c.add(interval(at(2, 10), 1, 3, 0.5))
+	c.add(interval(at(2, 16), 2, 0, 1))
+	c.add(energyInterval{Start: at(3, 12), End: at(3, 12).Add(10 * time.Minute), Generation: 1})
+	bill := c.bill("station", at(2, 0), at(4, 0))
+
+	near := func(a, b float64) bool { return math.Abs(a-b) < 1e-9 }
+	type costs struct {
+		supplyDays                       int
+		supply, importCost, exportCredit float64
+		subtotal, tax, total             float64
+		metered                          bool
+	}
+	check := func(name string, got BillCostsV2, want costs) {
+		t.Helper()
+		if got.SupplyDays != want.supplyDays || !near(got.SupplyCharge, want.supply) || !near(got.ImportCost, want.importCost) ||
+			!near(got.ExportCredit, want.exportCredit) || !near(got.Subtotal, want.subtotal) || !near(got.Tax, want.tax) ||
+			!near(got.Total, want.total) || got.Metered != want.metered {
+			t.Errorf("%s: got %+v, want %+v", name, got, want)
+		}
+	}
+
+	if bill.From != "2026-03-02" || bill.To != "2026-03-04" || bill.Currency != "AUD" || len(bill.Days) != 3 {
+		t.Fatalf("bill covers %s to %s in %q with %d days", bill.From, bill.To, bill.Currency, len(bill.Days))
+	}
+	// 1 + 1.2345 - 0.15 = 2.0845, rounded to cents before tax.
+	check("Monday", bill.Days[0].Costs, costs{1, 1, 1.2345, 0.15, 2.08, 0.21, 2.29, true})
+	check("Tuesday, unmetered", bill.Days[1].Costs, costs{1, 1, 0, 0, 1, 0.1, 1.1, false})
+	check("Wednesday, no readings", bill.Days[2].Costs, costs{1, 1, 0, 0, 1, 0.1, 1.1, true})
+	// The totals are rounded once, not summed from the rounded days.
+	check("totals", bill.Totals, costs{3, 3, 1.2345, 0.15, 4.08, 0.41, 4.49, false})
+
+	periods := bill.Totals.Periods
+	if len(periods) != 2 || periods[0].Name != defaultPeriod || periods[1].Name != "peak" {
+		t.Fatalf("periods = %+v, want other and peak", periods)
+	}
+	if !near(periods[0].ImportKWh, 1) || !near(periods[0].ImportCost, 0.2345) ||
+		!near(periods[0].SelfConsumptionKWh, 0.5) || !near(periods[0].AvoidedImportCost, 0.11725) {
+		t.Errorf("other = %+v", periods[0])
+	}
+	if !near(periods[1].ImportKWh, 2) || !near(periods[1].ImportCost, 1) ||
+		!near(periods[1].SelfConsumptionKWh, 1) || !near(periods[1].AvoidedImportCost, 0.5) {
+		t.Errorf("peak = %+v", periods[1])
+	}
+	if !near(bill.Totals.ImportKWh, 3) || !near(bill.Totals.ExportKWh, 3) || !near(bill.Totals.SelfConsumptionKWh, 1.5) {
+		t.Errorf("totals energy = %v imported, %v exported, %v self-consumed",
+			bill.Totals.ImportKWh, bill.Totals.ExportKWh, bill.Totals.SelfConsumptionKWh)
+	}
+}
+
+func TestRoundCents(t *testing.T) {
+	for _, test := range []struct{ amount, want float64 }{
+		{1.234, 1.23}, {1.235, 1.24}, {0.005, 0.01}, {-0.126, -0.13}, {2, 2},
+	} {
+		if got := roundCents(test.amount); got != test.want {
+			t.Errorf("roundCents(%v) = %v, want %v", test.amount, got, test.want)
+		}
+	}
+}
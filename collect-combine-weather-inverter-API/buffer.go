@@ -0,0 +1,298 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Buffer is an append-only JSON lines file of snapshots that could not be
+// written to the database. Entries are replayed oldest first; once maxEntries
+// is reached the oldest entries are dropped to make room. Replayed and
+// dropped entries are skipped by moving a head offset, kept in a file next to
+// the buffer, and the buffer is only rewritten once most of it is dead.
+type Buffer struct {
+	path       string
+	maxEntries int
+	mu         sync.Mutex
+	replaying  sync.Mutex
+	head       int64
+	size       int64
+	starts     []int64
+	// removed counts entries ever taken off the head, so a replay can tell
+	// whether the entry it saved is still the oldest.
+	removed int64
+}
+
+func openBuffer(path string, maxEntries int) (*Buffer, error) {
+	b := &Buffer{path: path, maxEntries: maxEntries}
+	if err := b.load(); err != nil {
+		return nil, err
+	}
+	b.setDepth()
+	return b, nil
+}
+
+// load finds the entries after the head offset. A line cut short by a crash is
+// truncated away so the next entry does not run into it, and a head offset
+// that is not at the start of a line is ignored, replaying everything: saving
+// an entry twice is harmless, losing one is not.
+func (b *Buffer) load() error {
+	b.head = b.readHead()
+	f, err := os.OpenFile(b.path, os.O_RDWR, 0600)
+	if os.IsNotExist(err) {
+		b.head = 0
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("buffer: %v", err)
+	}
+	defer f.Close()
+	var lines []int64
+	r := bufio.NewReader(f)
+	for offset := int64(0); ; {
+		line, err := r.ReadBytes('\n')
+		if err == io.EOF {
+			if len(line) > 0 {
+				if err := f.Truncate(offset); err != nil {
+					return fmt.Errorf("buffer: %v", err)
+				}
+			}
+			b.size = offset
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("buffer: %v", err)
+		}
+		if len(bytes.TrimSpace(line)) > 0 {
+			lines = append(lines, offset)
+		}
+		offset += int64(len(line))
+	}
+	if b.head != b.size && !containsOffset(lines, b.head) {
+		b.head = 0
+	}
+	for _, start := range lines {
+		if start >= b.head {
+			b.starts = append(b.starts, start)
+		}
+	}
+	return nil
+}
+
+func containsOffset(offsets []int64, offset int64) bool {
+	for _, o := range offsets {
+		if o == offset {
+			return true
+		}
+	}
+	return false
+}
+
+func (b *Buffer) Len() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return len(b.starts)
+}
+
+func (b *Buffer) append(snapshot storedSnapshot) error {
+	line, err := json.Marshal(snapshot)
+	if err != nil {
+		return err
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.maxEntries > 0 && len(b.starts) >= b.maxEntries {
+		drop := len(b.starts) - b.maxEntries + 1
+		if err := b.advance(drop); err != nil {
+			return err
+		}
+		metrics.bufferDropped.Add(int64(drop))
+	}
+	f, err := os.OpenFile(b.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return fmt.Errorf("buffer: %v", err)
+	}
+	defer f.Close()
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		f.Truncate(b.size)
+		return fmt.Errorf("buffer: %v", err)
+	}
+	if err := f.Sync(); err != nil {
+		return fmt.Errorf("buffer: %v", err)
+	}
+	b.starts = append(b.starts, b.size)
+	b.size += int64(len(line)) + 1
+	b.setDepth()
+	return nil
+}
+
+// replay saves buffered snapshots in order and stops at the first failure,
+// keeping that entry and everything after it for the next attempt. The
+// buffer is not locked while a snapshot is saved, so polls can still be
+// buffered behind a slow database.
+func (b *Buffer) replay(save func(storedSnapshot) error) error {
+	b.replaying.Lock()
+	defer b.replaying.Unlock()
+	for {
+		b.mu.Lock()
+		if len(b.starts) == 0 {
+			b.mu.Unlock()
+			return nil
+		}
+		removed := b.removed
+		line, err := b.oldest()
+		b.mu.Unlock()
+		if err != nil {
+			return err
+		}
+
+		var snapshot storedSnapshot
+		if err := json.Unmarshal(line, &snapshot); err != nil {
+			metrics.bufferDropped.Add(1)
+		} else if err := save(snapshot); err != nil {
+			return err
+		} else {
+			metrics.bufferReplayed.Add(1)
+		}
+
+		b.mu.Lock()
+		// A full buffer may have dropped the entry while it was being saved.
+		if b.removed == removed {
+			err = b.advance(1)
+		}
+		b.mu.Unlock()
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// oldest reads the entry at the head.
+func (b *Buffer) oldest() ([]byte, error) {
+	end := b.size
+	if len(b.starts) > 1 {
+		end = b.starts[1]
+	}
+	f, err := os.Open(b.path)
+	if err != nil {
+		return nil, fmt.Errorf("buffer: %v", err)
+	}
+	defer f.Close()
+	line := make([]byte, end-b.starts[0])
+	if _, err := f.ReadAt(line, b.starts[0]); err != nil {
+		return nil, fmt.Errorf("buffer: %v", err)
+	}
+	return bytes.TrimSpace(line), nil
+}
+
+// advance drops the n oldest entries by moving the head past them.
+func (b *Buffer) advance(n int) error {
+	if n >= len(b.starts) {
+		if err := b.clear(); err != nil {
+			return err
+		}
+		b.removed += int64(n)
+		return nil
+	}
+	head := b.starts[n]
+	if err := b.writeHead(head); err != nil {
+		return err
+	}
+	b.head = head
+	b.starts = b.starts[n:]
+	b.removed += int64(n)
+	b.setDepth()
+	if b.head > b.size/2 {
+		return b.compact()
+	}
+	return nil
+}
+
+// clear removes the buffer and its head offset once every entry is gone.
+func (b *Buffer) clear() error {
+	if err := os.Remove(b.path); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("buffer: %v", err)
+	}
+	if err := os.Remove(b.headPath()); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("buffer: %v", err)
+	}
+	b.head, b.size, b.starts = 0, 0, nil
+	b.setDepth()
+	return nil
+}
+
+// compact rewrites the buffer without the entries before the head. The head
+// offset is reset before the new file replaces the old one, so a crash in
+// between replays dropped entries again rather than skipping live ones.
+func (b *Buffer) compact() error {
+	src, err := os.Open(b.path)
+	if err != nil {
+		return fmt.Errorf("buffer: %v", err)
+	}
+	defer src.Close()
+	tmp, err := os.CreateTemp(filepath.Dir(b.path), filepath.Base(b.path)+".tmp*")
+	if err != nil {
+		return fmt.Errorf("buffer: %v", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := io.Copy(tmp, io.NewSectionReader(src, b.head, b.size-b.head)); err != nil {
+		tmp.Close()
+		return fmt.Errorf("buffer: %v", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("buffer: %v", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("buffer: %v", err)
+	}
+	if err := b.writeHead(0); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), b.path); err != nil {
+		return fmt.Errorf("buffer: %v", err)
+	}
+	for i := range b.starts {
+		b.starts[i] -= b.head
+	}
+	b.size -= b.head
+	b.head = 0
+	return nil
+}
+
+func (b *Buffer) headPath() string {
+	return b.path + ".head"
+}
+
+// readHead returns 0 when the head file is missing or unreadable, replaying
+// the whole buffer.
+func (b *Buffer) readHead() int64 {
+	data, err := os.ReadFile(b.headPath())
+	if err != nil {
+		return 0
+	}
+	head, err := strconv.ParseInt(strings.TrimSpace(string(data)), 10, 64)
+	if err != nil || head < 0 {
+		return 0
+	}
+	return head
+}
+
+func (b *Buffer) writeHead(head int64) error {
+	if err := os.WriteFile(b.headPath(), []byte(strconv.FormatInt(head, 10)+"\n"), 0600); err != nil {
+		return fmt.Errorf("buffer: %v", err)
+	}
+	return nil
+}
+
+func (b *Buffer) setDepth() {
+	metrics.bufferDepth.Store(int64(len(b.starts)))
+}
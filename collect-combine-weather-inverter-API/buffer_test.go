@@ -0,0 +1,148 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func bufferedSnapshot(i int) storedSnapshot {
+	return storedSnapshot{StationID: "station", CollectedAt: time.Date(2026, 3, 1, 0, i, 0, 0, time.UTC)}
+}
+
+func replayAll(t *testing.T, b *Buffer) []int {
+	t.Helper()
+	var minutes []int
+	err := b.replay(func(snapshot storedSnapshot) error {
+		minutes = append(minutes, snapshot.CollectedAt.Minute())
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return minutes
+}
+
+func sameInts(a []int, b []int) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestBufferDropsOldestWhenFull(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "buffer.jsonl")
+	b, err := openBuffer(path, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 8; i++ {
+		if err := b.append(bufferedSnapshot(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if b.Len() != 3 {
+		t.Fatalf("Len = %d, want 3", b.Len())
+	}
+	reopened, err := openBuffer(path, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := replayAll(t, reopened); !sameInts(got, []int{5, 6, 7}) {
+		t.Errorf("replayed %v, want [5 6 7]", got)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("buffer file left after replaying everything: %v", err)
+	}
+}
+
+func TestBufferKeepsFailedEntry(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "buffer.jsonl")
+	b, err := openBuffer(path, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 4; i++ {
+		if err := b.append(bufferedSnapshot(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	saved := 0
+	err = b.replay(func(snapshot storedSnapshot) error {
+		if snapshot.CollectedAt.Minute() == 2 {
+			return errors.New("database down")
+		}
+		saved++
+		return nil
+	})
+	if err == nil || saved != 2 {
+		t.Fatalf("replay saved %d and returned %v, want 2 and an error", saved, err)
+	}
+	reopened, err := openBuffer(path, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := replayAll(t, reopened); !sameInts(got, []int{2, 3}) {
+		t.Errorf("replayed %v, want [2 3]", got)
+	}
+}
+
+func TestBufferAppendDuringReplay(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "buffer.jsonl")
+	b, err := openBuffer(path, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.append(bufferedSnapshot(0))
+	b.append(bufferedSnapshot(1))
+	var replayed []int
+	err = b.replay(func(snapshot storedSnapshot) error {
+		replayed = append(replayed, snapshot.CollectedAt.Minute())
+		if snapshot.CollectedAt.Minute() == 0 {
+			// Fills the buffer, dropping the entry being saved.
+			if err := b.append(bufferedSnapshot(2)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !sameInts(replayed, []int{0, 1, 2}) || b.Len() != 0 {
+		t.Errorf("replayed %v with %d left, want [0 1 2] and none", replayed, b.Len())
+	}
+}
+
+func TestBufferTruncatesPartialLine(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "buffer.jsonl")
+	b, err := openBuffer(path, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.append(bufferedSnapshot(0))
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString(`{"StationID":"sta`)
+	f.Close()
+
+	reopened, err := openBuffer(path, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := reopened.append(bufferedSnapshot(1)); err != nil {
+		t.Fatal(err)
+	}
+	if got := replayAll(t, reopened); !sameInts(got, []int{0, 1}) {
+		t.Errorf("replayed %v, want [0 1]", got)
+	}
+}
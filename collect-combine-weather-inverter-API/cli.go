@@ -0,0 +1,252 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+)
+
+var (
+	listenAddr string
+	logger     = slog.New(slog.NewTextHandler(os.Stderr, nil))
+)
+
+type command struct {
+	name    string
+	summary string
+	run     func(args []string)
+}
+
+var commands = []command{
+	{"serve", "run the HTTP API and the collector (default)", runServe},
+	{"collect-once", "poll SEMS and the weather provider once and print the snapshot as JSON", runCollectOnce},
+	{"login-test", "check the SEMS credentials and station ID", runLoginTest},
+	{"weather-test", "fetch and print the current weather", runWeatherTest},
+	{"migrate", "create or upgrade the database tables", runMigrate},
+	{"export", "export stored readings as CSV or Parquet", runExport},
+	{"backfill", "recover missed days from the SEMS chart history", runBackfill},
+	{"import-legacy", "import rows written by the PowerShell collector", runImportLegacy},
+	{"config", "config print-default: print a commented reference config", runConfigCommand},
+	{"openapi", "print the OpenAPI spec of the HTTP API", runOpenAPI},
+	{"gen-client", "regenerate client/api_gen.go from the route table", runGenClient},
+}
+
+func usage() {
+	out := flag.CommandLine.Output()
+	fmt.Fprintf(out, "usage: %s [flags] [command] [command flags]\n\ncommands:\n", os.Args[0])
+	for _, cmd := range commands {
+		fmt.Fprintf(out, "  %-14s %s\n", cmd.name, cmd.summary)
+	}
+	fmt.Fprintln(out, "\nflags:")
+	flag.PrintDefaults()
+}
+
+func runCLI() {
+	logLevel := flag.String("log-level", "info", "debug, info, warn or error")
+	flag.StringVar(&configPath, "config", configPath, "path to the config file (.json, .yaml, .yml or .toml)")
+	flag.StringVar(&listenAddr, "listen", listenAddr, "address the HTTP API listens on (default: server.listen, then :22222)")
+	flag.Usage = usage
+	flag.Parse()
+
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(*logLevel)); err != nil {
+		log.Fatalf("-log-level: %v", err)
+	}
+	logger = slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: level}))
+
+	args := flag.Args()
+	if len(args) == 0 {
+		runServe(nil)
+		return
+	}
+	for _, cmd := range commands {
+		if cmd.name == args[0] {
+			cmd.run(args[1:])
+			return
+		}
+	}
+	fmt.Fprintf(flag.CommandLine.Output(), "unknown command %q\n\n", args[0])
+	usage()
+	os.Exit(2)
+}
+
+func runServe(args []string) {
+	flags := flag.NewFlagSet("serve", flag.ExitOnError)
+	flags.StringVar(&listenAddr, "listen", listenAddr, "address the HTTP API listens on")
+	flags.Parse(args)
+
+	configs, err := loadConfigSource(configPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	go configs.watch()
+	config := configs.Get()
+	server := config.Server
+	addr := listenAddr
+	if addr == "" {
+		addr = orDefault(server.Listen, ":22222")
+	}
+	tlsConfig, err := serverTLSConfig(server)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, os.Interrupt)
+	defer stop()
+
+	var store *Store
+	var buffer *Buffer
+	if config.Database.DSN != "" {
+		store, err = openStore(config.Database.DSN)
+		if err != nil {
+			log.Fatalf("database.dsn: %v", err)
+		}
+		defer store.Close()
+		if err := store.ensureSchema(); err != nil {
+			logger.Warn("database unavailable at startup", "err", err)
+		}
+		if config.Collector.BufferPath != "" {
+			buffer, err = openBuffer(config.Collector.BufferPath, config.Collector.BufferMaxEntries)
+			if err != nil {
+				log.Fatalf("collector.bufferPath: %v", err)
+			}
+		}
+	}
+	hub := newHub()
+	collectorDone := make(chan struct{})
+	go func() {
+		newCollector(configs, store, buffer, hub).run(ctx)
+		close(collectorDone)
+	}()
+
+	srv := &http.Server{
+		Addr:              addr,
+		Handler:           newRouter(configs, store, hub),
+		TLSConfig:         tlsConfig,
+		ReadHeaderTimeout: 10 * time.Second,
+		ReadTimeout:       time.Duration(server.ReadTimeoutSeconds) * time.Second,
+		WriteTimeout:      time.Duration(server.WriteTimeoutSeconds) * time.Second,
+	}
+	srv.RegisterOnShutdown(hub.close)
+	shutdownDone := make(chan struct{})
+	go func() {
+		defer close(shutdownDone)
+		<-ctx.Done()
+		timeout := time.Duration(server.ShutdownTimeoutSeconds) * time.Second
+		if timeout <= 0 {
+			timeout = 30 * time.Second
+		}
+		logger.Info("shutting down", "timeout", timeout)
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+		if err := srv.Shutdown(shutdownCtx); err != nil {
+			logger.Warn("requests still running at shutdown", "err", err)
+		}
+		select {
+		case <-collectorDone:
+		case <-shutdownCtx.Done():
+			logger.Warn("collector still running at shutdown")
+		}
+	}()
+
+	if !config.Auth.enabled() {
+		logger.Warn("no auth.apiKeys or auth.users configured, the API is open to anyone who can reach it")
+	}
+	logger.Info("listening", "addr", addr, "tls", tlsConfig != nil)
+	if tlsConfig != nil {
+		err = srv.ListenAndServeTLS("", "")
+	} else {
+		err = srv.ListenAndServe()
+	}
+	if err != http.ErrServerClosed {
+		log.Fatal(err)
+	}
+	<-shutdownDone
+}
+
+func runCollectOnce(args []string) {
+	flag.NewFlagSet("collect-once", flag.ExitOnError).Parse(args)
+	snapshot, err := collectSnapshot(importConfig())
+	if err != nil {
+		log.Fatalf("collect-once: %v", err)
+	}
+	printJSON(snapshot)
+}
+
+func runLoginTest(args []string) {
+	flag.NewFlagSet("login-test", flag.ExitOnError).Parse(args)
+	config := importConfig()
+	loginResponse, err := runLoginRequest(config)
+	if err != nil {
+		log.Fatalf("login-test: %v", err)
+	}
+	fmt.Printf("login ok: account %s, api %s\n", config.ClientConfig.LoginInfo.Account, loginResponse.API)
+	inverterData, err := getInverterData(config, loginResponse)
+	if err != nil {
+		log.Fatalf("login-test: %v", err)
+	}
+	info := inverterData.Data.Info
+	fmt.Printf("station ok: %s (%s), %d inverters\n", info.Stationname, config.ClientConfig.StationInfo.StationID, len(inverterData.Data.Inverter))
+}
+
+// runWeatherTest only logs in to SEMS when the weather location has to come
+// from the station coordinates.
+func runWeatherTest(args []string) {
+	flag.NewFlagSet("weather-test", flag.ExitOnError).Parse(args)
+	config := importConfig()
+	api := config.WeatherAPI
+	var latitude, longitude float64
+	ownLocation := hasCoordinates(api) ||
+		(api.Provider == "" || strings.EqualFold(api.Provider, "openweathermap")) && (api.CityID != "" || api.CityName != "" || api.ZipCode != "") ||
+		strings.EqualFold(api.Provider, "bom")
+	if !ownLocation {
+		loginResponse, err := runLoginRequest(config)
+		if err != nil {
+			log.Fatalf("weather-test: %v", err)
+		}
+		inverterData, err := getInverterData(config, loginResponse)
+		if err != nil {
+			log.Fatalf("weather-test: %v", err)
+		}
+		latitude, longitude = inverterData.Data.Info.Latitude, inverterData.Data.Info.Longitude
+	}
+	weather, err := getWeatherData(config, latitude, longitude)
+	if err != nil {
+		log.Fatalf("weather-test: %v", err)
+	}
+	printJSON(weather)
+}
+
+func runMigrate(args []string) {
+	flag.NewFlagSet("migrate", flag.ExitOnError).Parse(args)
+	config := importConfig()
+	if config.Database.DSN == "" {
+		log.Fatal("migrate: database.dsn is not configured")
+	}
+	store, err := openStore(config.Database.DSN)
+	if err != nil {
+		log.Fatalf("migrate: %v", err)
+	}
+	defer store.Close()
+	if err := store.ensureSchema(); err != nil {
+		log.Fatalf("migrate: %v", err)
+	}
+	fmt.Printf("schema at version %d\n", len(migrations))
+}
+
+func printJSON(v interface{}) {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Println(string(data))
+}
@@ -0,0 +1,829 @@
+// Code generated by collect-combine-weather-inverter-API gen-client. DO NOT EDIT.
+
+package client
+
+import (
+	"context"
+	"io"
+	"net/url"
+	"time"
+)
+
+type BillCostsV2 struct {
+	// Days charged the daily supply charge.
+	SupplyDays   int                 `json:"supply_days"`
+	SupplyCharge float64             `json:"supply_charge"`
+	Periods      []BillPeriodCostsV2 `json:"periods"`
+	// Unit: kWh.
+	ImportKWh  float64 `json:"import_kwh"`
+	ImportCost float64 `json:"import_cost"`
+	// Unit: kWh.
+	ExportKWh float64 `json:"export_kwh"`
+	// Subtracted from the bill.
+	ExportCredit float64 `json:"export_credit"`
+	// Unit: kWh.
+	SelfConsumptionKWh float64 `json:"self_consumption_kwh"`
+	// What the self-consumed energy would have cost to import. Not part of the bill.
+	AvoidedImportCost float64 `json:"avoided_import_cost"`
+	// supply_charge plus import_cost less export_credit, before tax.
+	Subtotal float64 `json:"subtotal"`
+	Tax      float64 `json:"tax"`
+	// What the retailer should bill.
+	Total float64 `json:"total"`
+	// Whether import and export were measured throughout. When false, energy during unmeasured intervals is missing from the bill.
+	Metered bool `json:"metered"`
+}
+
+type BillDayV2 struct {
+	// Local day, YYYY-MM-DD.
+	Date  string      `json:"date"`
+	Costs BillCostsV2 `json:"costs"`
+}
+
+type BillPeriodCostsV2 struct {
+	// tariff.timeOfUse name, or other.
+	Name string `json:"name"`
+	// Unit: kWh.
+	ImportKWh  float64 `json:"import_kwh"`
+	ImportCost float64 `json:"import_cost"`
+	// Unit: kWh.
+	SelfConsumptionKWh float64 `json:"self_consumption_kwh"`
+	AvoidedImportCost  float64 `json:"avoided_import_cost"`
+}
+
+type BillV2 struct {
+	StationID string `json:"station_id"`
+	// First day of the period, YYYY-MM-DD local time.
+	From string `json:"from"`
+	// Last day of the period, inclusive.
+	To       string      `json:"to"`
+	Currency string      `json:"currency"`
+	Totals   BillCostsV2 `json:"totals"`
+	Days     []BillDayV2 `json:"days"`
+}
+
+type ClippingDayV2 struct {
+	// Local day of the station, YYYY-MM-DD.
+	Date         string `json:"date"`
+	SerialNumber string `json:"serial_number"`
+	Name         string `json:"name"`
+	// Clipping is only looked for on sunny days.
+	Sunny bool `json:"sunny"`
+	// Mean over daylight readings, from measured irradiance when available. Null without observations. Unit: %.
+	CloudCoverPercent *float64 `json:"cloud_cover_percent"`
+	// Unit: W.
+	CapacityW float64 `json:"capacity_w"`
+	// PV array assumed behind the inverter: the station's capacity shared between its inverters by rating. 0 when the inventory has no station capacity. Unit: kW.
+	ArrayKW float64 `json:"array_kw"`
+	// Export power limit from the SEMS equipment settings. 0 when not set. Unit: W.
+	ExportLimitW float64 `json:"export_limit_w"`
+	// Unit: W.
+	PeakOutputW float64 `json:"peak_output_w"`
+	// Output over the stored readings. Unit: kWh.
+	EnergyKWh float64 `json:"energy_kwh"`
+	// Unit: min.
+	ClippedMinutes float64 `json:"clipped_minutes"`
+	// Estimated energy the weather allowed for above the limit while clipped. Unit: kWh.
+	LostKWh float64            `json:"lost_kwh"`
+	Periods []ClippingPeriodV2 `json:"periods"`
+}
+
+type ClippingPeriodV2 struct {
+	// First reading at the limit, UTC.
+	Start time.Time `json:"start"`
+	// Last reading at the limit, UTC.
+	End time.Time `json:"end"`
+	// capacity or export_limit, whichever is lower.
+	Limit string `json:"limit"`
+	// Unit: W.
+	LimitW float64 `json:"limit_w"`
+	// Unit: W.
+	MeanOutputW float64 `json:"mean_output_w"`
+	// Unit: kWh.
+	LostKWh float64 `json:"lost_kwh"`
+}
+
+type ClippingReportV2 struct {
+	StationID string    `json:"station_id"`
+	From      time.Time `json:"from"`
+	To        time.Time `json:"to"`
+	// Days with more cloud on average are not counted as sunny. Unit: %.
+	MaxCloudPercent float64 `json:"max_cloud_percent"`
+	// Estimated energy lost to clipping over all days. Unit: kWh.
+	LostKWh float64 `json:"lost_kwh"`
+	// One entry per inverter and local day with daylight readings.
+	Days []ClippingDayV2 `json:"days"`
+}
+
+type DailySummary struct {
+	Date string `json:"date"`
+	// Unit: kW.
+	Capacity float64 `json:"capacity"`
+	// Unit: kWh.
+	EnergyDay float64 `json:"dayoutput"`
+	// Unit: kWh.
+	ClearSkyEnergy   float64 `json:"clearskyoutput"`
+	PerformanceRatio float64 `json:"performanceratio"`
+	// Energy so far today per kW of capacity. Unit: kWh/kWp.
+	SpecificYield float64 `json:"specificyield"`
+	// Unix time. Unit: s.
+	Sunrise int `json:"sunrise"`
+	// Unix time. Unit: s.
+	Sunset int `json:"sunset"`
+}
+
+type DailySummaryV2 struct {
+	StationID string `json:"station_id"`
+	// Local day of the station, YYYY-MM-DD.
+	Date string `json:"date"`
+	// Unit: kW.
+	CapacityKW float64 `json:"capacity_kw"`
+	// Generation so far today. Unit: kWh.
+	EnergyKWh float64 `json:"energy_kwh"`
+	// Modelled generation for the whole day under a clear sky. Unit: kWh.
+	ClearSkyEnergyKWh float64 `json:"clear_sky_energy_kwh"`
+	// energy_kwh divided by clear_sky_energy_kwh.
+	PerformanceRatio float64 `json:"performance_ratio"`
+	// Unit: kWh/kWp.
+	SpecificYieldKWhPerKWp float64 `json:"specific_yield_kwh_per_kwp"`
+	// UTC.
+	Sunrise *time.Time `json:"sunrise"`
+	// UTC.
+	Sunset *time.Time `json:"sunset"`
+}
+
+type DayReportV2 struct {
+	// Local day, YYYY-MM-DD.
+	Date   string         `json:"date"`
+	Energy EnergyReportV2 `json:"energy"`
+	// SEMS's income for the day.
+	SEMSIncome float64 `json:"sems_income"`
+}
+
+type DeratingBaselineV2 struct {
+	SerialNumber string `json:"serial_number"`
+	Name         string `json:"name"`
+	// Median output over potential output on cooler readings; the expected output is the potential times this ratio.
+	PerformanceRatio float64 `json:"performance_ratio"`
+	// Unit: °C.
+	MaxTemperatureC float64 `json:"max_temperature_c"`
+}
+
+type DeratingEventV2 struct {
+	SerialNumber string `json:"serial_number"`
+	Name         string `json:"name"`
+	// First reading of the event, UTC.
+	Start time.Time `json:"start"`
+	// Last reading of the event, UTC.
+	End time.Time `json:"end"`
+	// Lowest temperature in the hour leading up to the event. Unit: °C.
+	StartTemperatureC float64 `json:"start_temperature_c"`
+	// Unit: °C.
+	PeakTemperatureC float64 `json:"peak_temperature_c"`
+	// Unit: W.
+	MeanOutputW float64 `json:"mean_output_w"`
+	// What the weather allowed for at the inverter's usual performance ratio. Unit: W.
+	MeanExpectedW float64 `json:"mean_expected_w"`
+	// Mean over the event. Null without cloud observations. Unit: %.
+	CloudCoverPercent *float64 `json:"cloud_cover_percent"`
+	// Estimated energy lost: expected less actual output over the event. Unit: kWh.
+	LostKWh  float64 `json:"lost_kwh"`
+	Readings int     `json:"readings"`
+}
+
+type DeratingReportV2 struct {
+	StationID string    `json:"station_id"`
+	From      time.Time `json:"from"`
+	To        time.Time `json:"to"`
+	// Inverter temperature from which a shortfall counts as derating. Unit: °C.
+	MinTemperatureC float64           `json:"min_temperature_c"`
+	Events          []DeratingEventV2 `json:"events"`
+	// Baseline of each inverter in the range.
+	Inverters []DeratingBaselineV2 `json:"inverters"`
+}
+
+type EnergyReportV2 struct {
+	// Unit: kWh.
+	GenerationKWh float64 `json:"generation_kwh"`
+	// Bought from the grid. Unit: kWh.
+	ImportKWh float64 `json:"import_kwh"`
+	// Sold to the grid. Unit: kWh.
+	ExportKWh float64 `json:"export_kwh"`
+	// Generation used on site: generation less export. Unit: kWh.
+	SelfConsumptionKWh float64 `json:"self_consumption_kwh"`
+	// Whether import and export were measured for the whole period.
+	Metered bool `json:"metered"`
+	// Import priced at the import rate in force at the time.
+	ImportCost float64 `json:"import_cost"`
+	// Export priced at the feed-in rate.
+	ExportCredit float64 `json:"export_credit"`
+	// Self-consumption priced at the import rate in force at the time.
+	AvoidedImportCost float64 `json:"avoided_import_cost"`
+	// export_credit plus avoided_import_cost.
+	Savings float64 `json:"savings"`
+}
+
+type GrafanaAnnotation struct {
+	Annotation GrafanaAnnotationQuery `json:"annotation"`
+	// Unix time. Unit: ms.
+	Time int64 `json:"time"`
+	// End of a fault, when it has ended within the range. Unit: ms.
+	TimeEnd int64    `json:"timeEnd,omitempty"`
+	Title   string   `json:"title"`
+	Text    string   `json:"text"`
+	Tags    []string `json:"tags"`
+}
+
+type GrafanaAnnotationQuery struct {
+	Name string `json:"name"`
+	// faults, weather, or empty for both.
+	Query string `json:"query"`
+}
+
+type GrafanaAnnotationRequest struct {
+	Range      GrafanaRange           `json:"range"`
+	Annotation GrafanaAnnotationQuery `json:"annotation"`
+}
+
+type GrafanaColumn struct {
+	Text string `json:"text"`
+	Type string `json:"type"`
+}
+
+type GrafanaOptions struct {
+	// Station ID. Default: the configured station.
+	Station string `json:"station"`
+}
+
+type GrafanaQueryRequest struct {
+	Range GrafanaRange `json:"range"`
+	// Width of each point; at least a minute is used. Unit: ms.
+	IntervalMs int64           `json:"intervalMs"`
+	Targets    []GrafanaTarget `json:"targets"`
+}
+
+type GrafanaQueryResult struct {
+	Target string `json:"target,omitempty"`
+	// [value, Unix time in ms] pairs.
+	Datapoints [][]float64     `json:"datapoints,omitempty"`
+	Type       string          `json:"type,omitempty"`
+	Columns    []GrafanaColumn `json:"columns,omitempty"`
+	Rows       [][]interface{} `json:"rows,omitempty"`
+}
+
+type GrafanaRange struct {
+	From time.Time `json:"from"`
+	To   time.Time `json:"to"`
+}
+
+type GrafanaSearchRequest struct {
+	// Only metrics containing this text are listed.
+	Target string `json:"target"`
+}
+
+type GrafanaTarget struct {
+	// A metric from /search: a station metric, or an inverter metric followed by :<serial number>.
+	Target string `json:"target"`
+	RefID  string `json:"refId,omitempty"`
+	// timeserie (default) or table.
+	Type    string          `json:"type,omitempty"`
+	Data    *GrafanaOptions `json:"data,omitempty"`
+	Payload *GrafanaOptions `json:"payload,omitempty"`
+}
+
+type InventoryChangeV2 struct {
+	// JSON key of the field that changed.
+	Field string `json:"field"`
+	From  string `json:"from"`
+	To    string `json:"to"`
+	// First poll that reported the new value.
+	ChangedAt time.Time `json:"changed_at"`
+}
+
+type InventoryEquipmentV2 struct {
+	SerialNumber    string `json:"serial_number"`
+	Name            string `json:"name"`
+	Model           string `json:"model"`
+	ModelType       string `json:"model_type"`
+	FirmwareVersion string `json:"firmware_version"`
+	DataloggerSN    string `json:"datalogger_sn"`
+	// As SEMS formats it.
+	CreationDate string `json:"creation_date"`
+	// Unit: kW.
+	CapacityKW float64 `json:"capacity_kw"`
+	// As SEMS reports it; empty when not set.
+	ExportPowerLimit string `json:"export_power_limit"`
+	// Target power factor, as SEMS reports it.
+	TargetPF  string    `json:"target_pf"`
+	FirstSeen time.Time `json:"first_seen"`
+	// Last poll that reported this equipment. Older than the station's last_seen once it was removed.
+	LastSeen time.Time `json:"last_seen"`
+	// Changes to the fields above, such as firmware upgrades or datalogger swaps, oldest first.
+	Changes []InventoryChangeV2 `json:"changes"`
+}
+
+type InventoryStationV2 struct {
+	StationID string `json:"station_id"`
+	Name      string `json:"name"`
+	Address   string `json:"address"`
+	Owner     string `json:"owner"`
+	// When the station was created in SEMS, as SEMS formats it.
+	CreateTime string `json:"create_time"`
+	// SEMS powerstation_type.
+	Type string `json:"type"`
+	// Unit: kW.
+	CapacityKW float64 `json:"capacity_kw"`
+	// Unit: kWh.
+	BatteryCapacityKWh float64   `json:"battery_capacity_kwh"`
+	FirstSeen          time.Time `json:"first_seen"`
+	LastSeen           time.Time `json:"last_seen"`
+	// Changes to the fields above, oldest first.
+	Changes   []InventoryChangeV2    `json:"changes"`
+	Equipment []InventoryEquipmentV2 `json:"equipment"`
+}
+
+type InventoryV2 struct {
+	Stations []InventoryStationV2 `json:"stations"`
+}
+
+type InverterV2 struct {
+	SerialNumber string `json:"serial_number"`
+	Name         string `json:"name"`
+	// Unit: kW.
+	CapacityKW float64 `json:"capacity_kw"`
+	// Current AC output. Unit: W.
+	OutputPowerW float64 `json:"output_power_w"`
+	// Generation so far in the station's local day. Unit: kWh.
+	EnergyTodayKWh float64 `json:"energy_today_kwh"`
+	// Unit: kWh.
+	EnergyMonthKWh float64 `json:"energy_month_kwh"`
+	// Lifetime generation. Unit: kWh.
+	EnergyTotalKWh float64 `json:"energy_total_kwh"`
+	// When SEMS last heard from the inverter, UTC.
+	ReadTime time.Time `json:"read_time"`
+	// When the inverter last came online, UTC. Null when unknown.
+	BootTime *time.Time `json:"boot_time"`
+	// SEMS status code: -1 offline, 0 waiting, 1 generating, 2 fault.
+	Status int `json:"status"`
+	// Modelled output under a clear sky. Unit: W.
+	ClearSkyOutputW float64 `json:"clear_sky_output_w"`
+	// Output divided by the output expected from the available irradiance, 0 to about 1.
+	PerformanceRatio float64 `json:"performance_ratio"`
+	// Energy since the inverter's previous SEMS reading per kW of capacity. 0 for the first reading seen. Unit: kWh/kWp.
+	SpecificYieldKWhPerKWp float64 `json:"specific_yield_kwh_per_kwp"`
+	// Unit: h.
+	WorkHours float64 `json:"work_hours"`
+	// Per MPPT string. Unit: V.
+	PVVoltageV []float64 `json:"pv_voltage_v"`
+	// Per MPPT string. Unit: A.
+	PVCurrentA []float64 `json:"pv_current_a"`
+	// Per phase. Unit: V.
+	ACVoltageV []float64 `json:"ac_voltage_v"`
+	// Per phase. Unit: A.
+	ACCurrentA []float64 `json:"ac_current_a"`
+	// Per phase. Unit: Hz.
+	ACFrequencyHz []float64 `json:"ac_frequency_hz"`
+	// Internal temperature of the inverter. Null when SEMS does not report it or the inverter is offline. Unit: °C.
+	TemperatureC *float64 `json:"temperature_c"`
+}
+
+type MonthlyReportV2 struct {
+	StationID string `json:"station_id"`
+	// Local month of the station, YYYY-MM.
+	Month string `json:"month"`
+	// tariff.currency, or the SEMS currency when unset.
+	Currency string         `json:"currency"`
+	Totals   EnergyReportV2 `json:"totals"`
+	// Figures as SEMS reports them, using the yield rate set in the SEMS portal.
+	SEMS SEMSReportV2  `json:"sems"`
+	Days []DayReportV2 `json:"days"`
+}
+
+type PowerCurveV2 struct {
+	StationID string `json:"station_id"`
+	// Width of each interval. Unit: s.
+	StepSeconds int            `json:"step_seconds"`
+	Points      []PowerPointV2 `json:"points"`
+}
+
+type PowerPointV2 struct {
+	// Start of the interval, UTC.
+	Time time.Time `json:"time"`
+	// Mean output of the station over the interval, summed over inverters. Unit: W.
+	OutputPowerW float64 `json:"output_power_w"`
+}
+
+type ResponseData struct {
+	InverterName string `json:"name"`
+	// Unit: kW.
+	InverterCapacity float64 `json:"capacity"`
+	// Unit: W.
+	EnergyCurrent float64 `json:"currentoutput"`
+	// Unit: kWh.
+	EnergyDay float64 `json:"dayoutput"`
+	// Unit: kWh.
+	EnergyMonth float64 `json:"monthOutput"`
+	// Unit: kWh.
+	EnergyTotal float64 `json:"totaloutput"`
+	// As SEMS reports it, in the station's local time and date format.
+	LastRead string `json:"readtime"`
+	// As SEMS reports it.
+	OnlineSince string `json:"boottime"`
+	// Unit: °C.
+	CurrentTemperature float64 `json:"currenttemp"`
+	// Unit: %.
+	CloudPercent       int    `json:"cloudpercent"`
+	WeatherType        string `json:"weather"`
+	WeatherDescription string `json:"weatherdesc"`
+	// Unix time. Unit: s.
+	Sunrise int `json:"sunrise"`
+	// Unix time. Unit: s.
+	Sunset int `json:"sunset"`
+	// Unit: W/m².
+	GHI float64 `json:"ghi"`
+	// Unit: W/m².
+	DNI float64 `json:"dni"`
+	// Unit: W/m².
+	DHI float64 `json:"dhi"`
+	// Unit: W.
+	ClearSkyOutput   float64 `json:"clearskyoutput"`
+	PerformanceRatio float64 `json:"performanceratio"`
+	// Energy since the inverter's previous SEMS reading per kW of capacity. Unit: kWh/kWp.
+	SpecificYield float64 `json:"specificyield"`
+}
+
+type SEMSReportV2 struct {
+	Currency string `json:"currency"`
+	// Income per kWh set in the SEMS portal.
+	YieldRate float64 `json:"yield_rate"`
+	// Sum of SEMS's daily income over the month.
+	IncomeMonth float64 `json:"income_month"`
+	// Lifetime income at the end of the month.
+	IncomeTotal float64 `json:"income_total"`
+	// Lifetime CO2 avoided at the end of the month, as SEMS reports it.
+	CO2Avoided float64 `json:"co2_avoided"`
+	// Lifetime equivalent trees planted.
+	TreesPlanted float64 `json:"trees_planted"`
+	// Lifetime standard coal saved, as SEMS reports it.
+	CoalSaved float64 `json:"coal_saved"`
+}
+
+type SnapshotV2 struct {
+	// SEMS power station ID.
+	StationID   string `json:"station_id"`
+	StationName string `json:"station_name"`
+	// When the service polled SEMS, UTC.
+	CollectedAt time.Time `json:"collected_at"`
+	// Zone used for the station's local day, an IANA name or a fixed offset.
+	Timezone string `json:"timezone"`
+	// Installed capacity of the station. Unit: kW.
+	CapacityKW float64 `json:"capacity_kw"`
+	// State of charge of the station battery. Null without one. Unit: %.
+	BatterySOCPercent *float64     `json:"battery_soc_percent"`
+	Inverters         []InverterV2 `json:"inverters"`
+	Weather           WeatherV2    `json:"weather"`
+}
+
+type WeatherV2 struct {
+	// openweathermap, openmeteo, bom or metno.
+	Provider string `json:"provider"`
+	// Time of the observation, UTC.
+	ObservedAt time.Time `json:"observed_at"`
+	// Unit: °C.
+	TemperatureC float64 `json:"temperature_c"`
+	// Unit: %.
+	HumidityPercent float64 `json:"humidity_percent"`
+	// Unit: hPa.
+	PressureHPa float64 `json:"pressure_hpa"`
+	// Unit: m/s.
+	WindSpeedMS float64 `json:"wind_speed_m_s"`
+	// Direction the wind comes from, clockwise from north. Unit: °.
+	WindDirectionDeg float64 `json:"wind_direction_deg"`
+	// Unit: %.
+	CloudCoverPercent int `json:"cloud_cover_percent"`
+	// Short condition, e.g. Clouds.
+	Condition   string `json:"condition"`
+	Description string `json:"description"`
+	// UTC.
+	Sunrise *time.Time `json:"sunrise"`
+	// UTC.
+	Sunset *time.Time `json:"sunset"`
+	// Global horizontal irradiance. 0 when the provider has none. Unit: W/m².
+	GHIWM2 float64 `json:"ghi_w_m2"`
+	// Direct normal irradiance. Unit: W/m².
+	DNIWM2 float64 `json:"dni_w_m2"`
+	// Diffuse horizontal irradiance. Unit: W/m².
+	DHIWM2 float64 `json:"dhi_w_m2"`
+}
+
+// GetInverterDataV1 calls GET /v1/getinverterdata: Live reading of the first inverter with the current weather.
+// Polls SEMS and the weather provider.
+func (c *Client) GetInverterDataV1(ctx context.Context) (*ResponseData, error) {
+	var result ResponseData
+	if err := c.decode(ctx, "GET", "/v1/getinverterdata", nil, nil, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// GetDailySummaryV1 calls GET /v1/getdailysummary: Today's generation against the clear-sky model.
+// Polls SEMS.
+func (c *Client) GetDailySummaryV1(ctx context.Context) (*DailySummary, error) {
+	var result DailySummary
+	if err := c.decode(ctx, "GET", "/v1/getdailysummary", nil, nil, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// GetSnapshot calls GET /v2/snapshot: Live readings of every inverter with the current weather.
+// Polls SEMS and the weather provider.
+func (c *Client) GetSnapshot(ctx context.Context) (*SnapshotV2, error) {
+	var result SnapshotV2
+	if err := c.decode(ctx, "GET", "/v2/snapshot", nil, nil, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// GetDailySummary calls GET /v2/daily-summary: Today's generation against the clear-sky model.
+func (c *Client) GetDailySummary(ctx context.Context) (*DailySummaryV2, error) {
+	var result DailySummaryV2
+	if err := c.decode(ctx, "GET", "/v2/daily-summary", nil, nil, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// GetPowerCurveParams are the query parameters of GetPowerCurve.
+type GetPowerCurveParams struct {
+	// Station ID. Default: the configured station.
+	Station string
+	// Start of the range, RFC 3339 or YYYY-MM-DD (UTC). Required.
+	From string
+	// End of the range, exclusive. Default: now.
+	To string
+	// Interval length in seconds, at least 60. Default: 300.
+	Step string
+}
+
+// GetPowerCurve calls GET /v2/power-curve: Stored station output averaged over fixed intervals.
+// Intervals without readings are left out. 503 when no database is configured.
+func (c *Client) GetPowerCurve(ctx context.Context, params GetPowerCurveParams) (*PowerCurveV2, error) {
+	query := url.Values{}
+	if params.Station != "" {
+		query.Set("station", params.Station)
+	}
+	if params.From != "" {
+		query.Set("from", params.From)
+	}
+	if params.To != "" {
+		query.Set("to", params.To)
+	}
+	if params.Step != "" {
+		query.Set("step", params.Step)
+	}
+	var result PowerCurveV2
+	if err := c.decode(ctx, "GET", "/v2/power-curve", query, nil, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// GetMonthlyReportParams are the query parameters of GetMonthlyReport.
+type GetMonthlyReportParams struct {
+	// Local month of the station, YYYY-MM. Required.
+	Month string
+	// Station ID. Default: the configured station.
+	Station string
+}
+
+// GetMonthlyReport calls GET /v2/reports/monthly: Energy, savings and SEMS income for a month, with a breakdown per day.
+// Savings are priced with the configured tariff from the meter's import and export counters stored with each poll. 503 when no database is configured.
+func (c *Client) GetMonthlyReport(ctx context.Context, params GetMonthlyReportParams) (*MonthlyReportV2, error) {
+	query := url.Values{}
+	if params.Month != "" {
+		query.Set("month", params.Month)
+	}
+	if params.Station != "" {
+		query.Set("station", params.Station)
+	}
+	var result MonthlyReportV2
+	if err := c.decode(ctx, "GET", "/v2/reports/monthly", query, nil, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// GetBillParams are the query parameters of GetBill.
+type GetBillParams struct {
+	// First local day, YYYY-MM-DD. Default: the start of the current billing period.
+	From string
+	// Last local day, inclusive, YYYY-MM-DD. Required with from.
+	To string
+	// Station ID. Default: the configured station.
+	Station string
+}
+
+// GetBill calls GET /v2/reports/bill: What the retailer should bill for a period, with a breakdown per day and time-of-use period.
+// Prices the metered import and export stored with each poll with the configured tariff, including the daily supply charge and tax. 503 when no database is configured.
+func (c *Client) GetBill(ctx context.Context, params GetBillParams) (*BillV2, error) {
+	query := url.Values{}
+	if params.From != "" {
+		query.Set("from", params.From)
+	}
+	if params.To != "" {
+		query.Set("to", params.To)
+	}
+	if params.Station != "" {
+		query.Set("station", params.Station)
+	}
+	var result BillV2
+	if err := c.decode(ctx, "GET", "/v2/reports/bill", query, nil, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// GetDeratingEventsParams are the query parameters of GetDeratingEvents.
+type GetDeratingEventsParams struct {
+	// Start of the range, RFC 3339 or YYYY-MM-DD (UTC). Default: a week before to.
+	From string
+	// End of the range, exclusive. Default: now.
+	To string
+	// Station ID. Default: the configured station.
+	Station string
+	// Inverter temperature in °C from which a shortfall counts as derating. Default: 50.
+	Min_temperature string
+	// How far below the expected output a reading must be. Default: 15.
+	Shortfall_percent string
+}
+
+// GetDeratingEvents calls GET /v2/reports/derating: Stretches where an inverter held its output back as it heated up.
+// Compares stored output with what the weather allowed for at the inverter's usual performance ratio. A shortfall while the inverter is hot and its temperature rose going into it is reported as a derating event. 503 when no database is configured.
+func (c *Client) GetDeratingEvents(ctx context.Context, params GetDeratingEventsParams) (*DeratingReportV2, error) {
+	query := url.Values{}
+	if params.From != "" {
+		query.Set("from", params.From)
+	}
+	if params.To != "" {
+		query.Set("to", params.To)
+	}
+	if params.Station != "" {
+		query.Set("station", params.Station)
+	}
+	if params.Min_temperature != "" {
+		query.Set("min_temperature", params.Min_temperature)
+	}
+	if params.Shortfall_percent != "" {
+		query.Set("shortfall_percent", params.Shortfall_percent)
+	}
+	var result DeratingReportV2
+	if err := c.decode(ctx, "GET", "/v2/reports/derating", query, nil, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// GetClippingParams are the query parameters of GetClipping.
+type GetClippingParams struct {
+	// Start of the range, RFC 3339 or YYYY-MM-DD (UTC). Default: a week before to.
+	From string
+	// End of the range, exclusive. Default: now.
+	To string
+	// Station ID. Default: the configured station.
+	Station string
+	// Mean daylight cloud cover up to which a day counts as sunny. Default: 30.
+	Max_cloud_percent string
+}
+
+// GetClipping calls GET /v2/reports/clipping: Energy lost each sunny day to output held at the inverter rating or export limit.
+// Finds stretches of stored readings where output flatlines at the lower of the inverter's capacity and its SEMS export power limit, and estimates what the weather allowed for above it. 503 when no database is configured.
+func (c *Client) GetClipping(ctx context.Context, params GetClippingParams) (*ClippingReportV2, error) {
+	query := url.Values{}
+	if params.From != "" {
+		query.Set("from", params.From)
+	}
+	if params.To != "" {
+		query.Set("to", params.To)
+	}
+	if params.Station != "" {
+		query.Set("station", params.Station)
+	}
+	if params.Max_cloud_percent != "" {
+		query.Set("max_cloud_percent", params.Max_cloud_percent)
+	}
+	var result ClippingReportV2
+	if err := c.decode(ctx, "GET", "/v2/reports/clipping", query, nil, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// StreamSnapshotsParams are the query parameters of StreamSnapshots.
+type StreamSnapshotsParams struct {
+	// Comma-separated station IDs. Default: every station.
+	Station string
+	// Comma-separated fields to send: top-level keys of /v2/snapshot, inverter keys as inverters.<key> and weather keys as weather.<key>, e.g. inverters.capacity_kw for each inverter's capacity and capacity_kw for the station's. An inverter key without the prefix works when no top-level key has its name. station_id, collected_at and serial_number are always sent. Default: all.
+	Fields string
+}
+
+// StreamSnapshots calls GET /stream: Each new snapshot as it is collected, over server-sent events or a WebSocket.
+// Sends the latest snapshot on connect, then one event per poll that brought new readings, in the /v2/snapshot shape. Upgrades to a WebSocket with one JSON message per snapshot when asked to.
+func (c *Client) StreamSnapshots(ctx context.Context, params StreamSnapshotsParams) (io.ReadCloser, error) {
+	query := url.Values{}
+	if params.Station != "" {
+		query.Set("station", params.Station)
+	}
+	if params.Fields != "" {
+		query.Set("fields", params.Fields)
+	}
+	return c.stream(ctx, "GET", "/stream", query, nil)
+}
+
+// ExportReadingsParams are the query parameters of ExportReadings.
+type ExportReadingsParams struct {
+	// Station ID. Default: the configured station.
+	Station string
+	// Start of the range, RFC 3339 or YYYY-MM-DD (UTC). Default: a week before to. At most five years before to.
+	From string
+	// End of the range, exclusive. Default: now.
+	To string
+	// Default: csv. One of csv, parquet.
+	Format string
+}
+
+// ExportReadings calls GET /export: Stored readings as CSV or Parquet.
+// One row per inverter reading with the weather observed alongside it, streamed from the database. 503 when no database is configured.
+func (c *Client) ExportReadings(ctx context.Context, params ExportReadingsParams) (io.ReadCloser, error) {
+	query := url.Values{}
+	if params.Station != "" {
+		query.Set("station", params.Station)
+	}
+	if params.From != "" {
+		query.Set("from", params.From)
+	}
+	if params.To != "" {
+		query.Set("to", params.To)
+	}
+	if params.Format != "" {
+		query.Set("format", params.Format)
+	}
+	return c.stream(ctx, "GET", "/export", query, nil)
+}
+
+// GrafanaSearch calls POST /search: Metrics for the Grafana SimpleJSON datasource.
+// Station metrics, then inverter metrics as <metric>:<serial number> for the inverters seen in the last year.
+func (c *Client) GrafanaSearch(ctx context.Context, body GrafanaSearchRequest) ([]string, error) {
+	var result []string
+	err := c.decode(ctx, "POST", "/search", nil, body, &result)
+	return result, err
+}
+
+// GrafanaQuery calls POST /query: Stored readings as Grafana time series or tables.
+// Each target is averaged over intervalMs. Station power and energy add the inverters up; ratios and weather are averaged. 503 when no database is configured.
+func (c *Client) GrafanaQuery(ctx context.Context, body GrafanaQueryRequest) ([]GrafanaQueryResult, error) {
+	var result []GrafanaQueryResult
+	err := c.decode(ctx, "POST", "/query", nil, body, &result)
+	return result, err
+}
+
+// GrafanaAnnotations calls POST /annotations: Inverter faults and weather changes as Grafana annotations.
+// annotation.query selects faults, weather or, when empty, both. 503 when no database is configured.
+func (c *Client) GrafanaAnnotations(ctx context.Context, body GrafanaAnnotationRequest) ([]GrafanaAnnotation, error) {
+	var result []GrafanaAnnotation
+	err := c.decode(ctx, "POST", "/annotations", nil, body, &result)
+	return result, err
+}
+
+// GetInventoryParams are the query parameters of GetInventory.
+type GetInventoryParams struct {
+	// Station ID. Default: every station.
+	Station string
+}
+
+// GetInventory calls GET /inventory: Equipment per station with its model, firmware and datalogger, and how they changed.
+// Recorded from every poll, so changes such as firmware upgrades or datalogger swaps show up with the time they were first seen. 503 when no database is configured.
+func (c *Client) GetInventory(ctx context.Context, params GetInventoryParams) (*InventoryV2, error) {
+	query := url.Values{}
+	if params.Station != "" {
+		query.Set("station", params.Station)
+	}
+	var result InventoryV2
+	if err := c.decode(ctx, "GET", "/inventory", query, nil, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// GetMetrics calls GET /metrics: Collector metrics in the Prometheus text format.
+func (c *Client) GetMetrics(ctx context.Context) (io.ReadCloser, error) {
+	return c.stream(ctx, "GET", "/metrics", nil, nil)
+}
+
+// GetOpenAPI calls GET /openapi.json: This OpenAPI document.
+func (c *Client) GetOpenAPI(ctx context.Context) (map[string]interface{}, error) {
+	var result map[string]interface{}
+	err := c.decode(ctx, "GET", "/openapi.json", nil, nil, &result)
+	return result, err
+}
@@ -0,0 +1,102 @@
+// Package client calls the HTTP API of collect-combine-weather-inverter-API.
+//
+// The response types and methods in api_gen.go are generated from the
+// service's route table by its gen-client command; do not edit them by hand.
+package client
+
+//go:generate go run .. gen-client -output .
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+type Client struct {
+	// BaseURL is the address of the service, e.g. http://localhost:22222.
+	BaseURL    string
+	HTTPClient *http.Client
+	// APIKey is sent as X-API-Key when set; otherwise Username and
+	// Password are sent as basic auth when set.
+	APIKey   string
+	Username string
+	Password string
+}
+
+func New(baseURL string) *Client {
+	return &Client{BaseURL: strings.TrimRight(baseURL, "/"), HTTPClient: http.DefaultClient}
+}
+
+// Error is returned for any response other than 200.
+type Error struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%d %s: %s", e.StatusCode, http.StatusText(e.StatusCode), e.Message)
+}
+
+// stream sends the request, with body encoded as JSON unless it is nil, and
+// returns the body of a 200 response, which the caller must close.
+func (c *Client) stream(ctx context.Context, method, path string, query url.Values, body interface{}) (io.ReadCloser, error) {
+	target := c.BaseURL + path
+	if len(query) > 0 {
+		target += "?" + query.Encode()
+	}
+	var reqBody io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		reqBody = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, target, reqBody)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.APIKey != "" {
+		req.Header.Set("X-API-Key", c.APIKey)
+	} else if c.Username != "" {
+		req.SetBasicAuth(c.Username, c.Password)
+	}
+	httpClient := c.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		data, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		message := strings.TrimSpace(string(data))
+		var apiErr struct {
+			Error string `json:"error"`
+		}
+		if json.Unmarshal(data, &apiErr) == nil && apiErr.Error != "" {
+			message = apiErr.Error
+		}
+		return nil, &Error{StatusCode: resp.StatusCode, Message: message}
+	}
+	return resp.Body, nil
+}
+
+func (c *Client) decode(ctx context.Context, method, path string, query url.Values, body interface{}, v interface{}) error {
+	resp, err := c.stream(ctx, method, path, query, body)
+	if err != nil {
+		return err
+	}
+	defer resp.Close()
+	return json.NewDecoder(resp).Decode(v)
+}
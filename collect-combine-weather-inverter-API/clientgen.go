@@ -0,0 +1,198 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"go/format"
+	"log"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// runGenClient writes client/api_gen.go: the response types and one method
+// per route, taken from apiRoutes.
+func runGenClient(args []string) {
+	flags := flag.NewFlagSet("gen-client", flag.ExitOnError)
+	output := flags.String("output", "client", "directory of the client package")
+	flags.Parse(args)
+	source, err := generateClient()
+	if err != nil {
+		log.Fatalf("gen-client: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(*output, "api_gen.go"), source, 0644); err != nil {
+		log.Fatalf("gen-client: %v", err)
+	}
+}
+
+type clientGenerator struct {
+	types   map[string]reflect.Type
+	imports map[string]bool
+}
+
+func generateClient() ([]byte, error) {
+	g := &clientGenerator{types: make(map[string]reflect.Type), imports: map[string]bool{"context": true}}
+	var methods bytes.Buffer
+	for _, route := range apiRoutes(nil, nil, nil) {
+		if route.Operation == "" {
+			continue
+		}
+		g.method(&methods, route)
+	}
+
+	var out bytes.Buffer
+	fmt.Fprintln(&out, "// Code generated by collect-combine-weather-inverter-API gen-client. DO NOT EDIT.")
+	fmt.Fprintln(&out)
+	fmt.Fprintln(&out, "package client")
+	fmt.Fprintln(&out)
+	// Rendering a struct can reach further structs, so repeat until none
+	// are left.
+	rendered := make(map[string][]byte)
+	for len(rendered) < len(g.types) {
+		for name, t := range g.types {
+			if _, ok := rendered[name]; !ok {
+				var b bytes.Buffer
+				g.structType(&b, t)
+				rendered[name] = b.Bytes()
+			}
+		}
+	}
+	names := make([]string, 0, len(rendered))
+	for name := range rendered {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var types bytes.Buffer
+	for _, name := range names {
+		types.Write(rendered[name])
+	}
+	imports := make([]string, 0, len(g.imports))
+	for path := range g.imports {
+		imports = append(imports, path)
+	}
+	sort.Strings(imports)
+	fmt.Fprintln(&out, "import (")
+	for _, path := range imports {
+		fmt.Fprintf(&out, "\t%q\n", path)
+	}
+	fmt.Fprintln(&out, ")")
+	out.Write(types.Bytes())
+	out.Write(methods.Bytes())
+	return format.Source(out.Bytes())
+}
+
+func exportedName(name string) string {
+	r := []rune(name)
+	r[0] = unicode.ToUpper(r[0])
+	return string(r)
+}
+
+func (g *clientGenerator) method(out *bytes.Buffer, route apiRoute) {
+	name := exportedName(route.Operation)
+	paramsArg := ""
+	if len(route.Params) > 0 {
+		fmt.Fprintf(out, "\n// %sParams are the query parameters of %s.\n", name, name)
+		fmt.Fprintf(out, "type %sParams struct {\n", name)
+		for _, p := range route.Params {
+			doc := p.Description
+			if len(p.Enum) > 0 {
+				doc += " One of " + strings.Join(p.Enum, ", ") + "."
+			}
+			if p.Required {
+				doc += " Required."
+			}
+			fmt.Fprintf(out, "\t// %s\n\t%s string\n", strings.TrimSpace(doc), exportedName(p.Name))
+		}
+		fmt.Fprintln(out, "}")
+		paramsArg = ", params " + name + "Params"
+	}
+	fmt.Fprintf(out, "\n// %s calls %s %s: %s.\n", name, route.Method, route.Path, route.Summary)
+	if route.Description != "" {
+		fmt.Fprintf(out, "// %s\n", route.Description)
+	}
+	body := "nil"
+	if route.Request != nil {
+		paramsArg += ", body " + g.typeExpr(reflect.TypeOf(route.Request))
+		body = "body"
+	}
+	resultType := "io.ReadCloser"
+	if route.ContentType != "" {
+		g.imports["io"] = true
+	} else {
+		resultType = g.typeExpr(reflect.TypeOf(route.Response))
+		if kind := reflect.TypeOf(route.Response).Kind(); kind != reflect.Map && kind != reflect.Slice {
+			resultType = "*" + resultType
+		}
+	}
+	fmt.Fprintf(out, "func (c *Client) %s(ctx context.Context%s) (%s, error) {\n", name, paramsArg, resultType)
+	query := "nil"
+	if len(route.Params) > 0 {
+		g.imports["net/url"] = true
+		query = "query"
+		fmt.Fprintln(out, "\tquery := url.Values{}")
+	}
+	for _, p := range route.Params {
+		field := exportedName(p.Name)
+		fmt.Fprintf(out, "\tif params.%s != \"\" {\n\t\tquery.Set(%q, params.%s)\n\t}\n", field, p.Name, field)
+	}
+	switch {
+	case route.ContentType != "":
+		fmt.Fprintf(out, "\treturn c.stream(ctx, %q, %q, %s, %s)\n", route.Method, route.Path, query, body)
+	case strings.HasPrefix(resultType, "*"):
+		fmt.Fprintf(out, "\tvar result %s\n", strings.TrimPrefix(resultType, "*"))
+		fmt.Fprintf(out, "\tif err := c.decode(ctx, %q, %q, %s, %s, &result); err != nil {\n\t\treturn nil, err\n\t}\n", route.Method, route.Path, query, body)
+		fmt.Fprintln(out, "\treturn &result, nil")
+	default:
+		fmt.Fprintf(out, "\tvar result %s\n", resultType)
+		fmt.Fprintf(out, "\terr := c.decode(ctx, %q, %q, %s, %s, &result)\n", route.Method, route.Path, query, body)
+		fmt.Fprintln(out, "\treturn result, err")
+	}
+	fmt.Fprintln(out, "}")
+}
+
+func (g *clientGenerator) typeExpr(t reflect.Type) string {
+	if t == timeType {
+		g.imports["time"] = true
+		return "time.Time"
+	}
+	switch t.Kind() {
+	case reflect.Ptr:
+		return "*" + g.typeExpr(t.Elem())
+	case reflect.Slice, reflect.Array:
+		return "[]" + g.typeExpr(t.Elem())
+	case reflect.Map:
+		return "map[" + g.typeExpr(t.Key()) + "]" + g.typeExpr(t.Elem())
+	case reflect.Interface:
+		return "interface{}"
+	case reflect.Struct:
+		if t.Name() == "" {
+			return "struct{}"
+		}
+		g.types[t.Name()] = t
+		return t.Name()
+	}
+	return t.Kind().String()
+}
+
+func (g *clientGenerator) structType(out *bytes.Buffer, t reflect.Type) {
+	fmt.Fprintf(out, "\ntype %s struct {\n", t.Name())
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() || field.Tag.Get("json") == "-" {
+			continue
+		}
+		doc := field.Tag.Get("doc")
+		if unit := field.Tag.Get("unit"); unit != "" && unit != "1" {
+			doc = strings.TrimSpace(doc + " Unit: " + unit + ".")
+		}
+		if doc != "" {
+			fmt.Fprintf(out, "\t// %s\n", doc)
+		}
+		fmt.Fprintf(out, "\t%s %s `json:%q`\n", field.Name, g.typeExpr(field.Type), field.Tag.Get("json"))
+	}
+	fmt.Fprintln(out, "}")
+}
@@ -0,0 +1,312 @@
+package main
+
+import (
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+)
+
+type ClippingReportV2 struct {
+	StationID       string          `json:"station_id"`
+	From            time.Time       `json:"from"`
+	To              time.Time       `json:"to"`
+	MaxCloudPercent float64         `json:"max_cloud_percent" unit:"%" doc:"Days with more cloud on average are not counted as sunny."`
+	LostKWh         float64         `json:"lost_kwh" unit:"kWh" doc:"Estimated energy lost to clipping over all days."`
+	Days            []ClippingDayV2 `json:"days" doc:"One entry per inverter and local day with daylight readings."`
+}
+
+type ClippingDayV2 struct {
+	Date              string             `json:"date" doc:"Local day of the station, YYYY-MM-DD."`
+	SerialNumber      string             `json:"serial_number"`
+	Name              string             `json:"name"`
+	Sunny             bool               `json:"sunny" doc:"Clipping is only looked for on sunny days."`
+	CloudCoverPercent *float64           `json:"cloud_cover_percent" unit:"%" doc:"Mean over daylight readings, from measured irradiance when available. Null without observations."`
+	CapacityW         float64            `json:"capacity_w" unit:"W"`
+	ArrayKW           float64            `json:"array_kw" unit:"kW" doc:"PV array assumed behind the inverter: the station's capacity shared between its inverters by rating. 0 when the inventory has no station capacity."`
+	ExportLimitW      float64            `json:"export_limit_w" unit:"W" doc:"Export power limit from the SEMS equipment settings. 0 when not set."`
+	PeakOutputW       float64            `json:"peak_output_w" unit:"W"`
+	EnergyKWh         float64            `json:"energy_kwh" unit:"kWh" doc:"Output over the stored readings."`
+	ClippedMinutes    float64            `json:"clipped_minutes" unit:"min"`
+	LostKWh           float64            `json:"lost_kwh" unit:"kWh" doc:"Estimated energy the weather allowed for above the limit while clipped."`
+	Periods           []ClippingPeriodV2 `json:"periods"`
+}
+
+type ClippingPeriodV2 struct {
+	Start       time.Time `json:"start" doc:"First reading at the limit, UTC."`
+	End         time.Time `json:"end" doc:"Last reading at the limit, UTC."`
+	Limit       string    `json:"limit" doc:"capacity or export_limit, whichever is lower."`
+	LimitW      float64   `json:"limit_w" unit:"W"`
+	MeanOutputW float64   `json:"mean_output_w" unit:"W"`
+	LostKWh     float64   `json:"lost_kwh" unit:"kWh"`
+}
+
+// clipTolerance is how close to a limit output must be to count as held at
+// it; minClipReadings is how many such readings in a row make a flatline.
+const (
+	clipTolerance   = 0.03
+	minClipReadings = 2
+)
+
+// timedValue is a setting in force from a time onwards.
+type timedValue struct {
+	since time.Time
+	value float64
+}
+
+// parseExportLimit reads the SEMS exportPowerlimit setting in W. Some models
+// report it in kW, so values up to 100 are read as kW.
+func parseExportLimit(value string) float64 {
+	limit, err := strconv.ParseFloat(value, 64)
+	if err != nil || limit <= 0 {
+		return 0
+	}
+	if limit <= 100 {
+		return limit * 1000
+	}
+	return limit
+}
+
+// inventoryHistory returns the recorded values of an equipment inventory
+// field by serial number, with the station under "", oldest first.
+func (s *Store) inventoryHistory(stationID string, field string, parse func(string) float64) (map[string][]timedValue, error) {
+	if err := s.ensureSchema(); err != nil {
+		return nil, err
+	}
+	rows, err := s.db.Query(`select equipment_sn, value, first_seen from equipment_inventory
+		where station_id = ? and field = ? order by equipment_sn, first_seen`, stationID, field)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	history := make(map[string][]timedValue)
+	for rows.Next() {
+		var sn, value string
+		var since time.Time
+		if err := rows.Scan(&sn, &value, &since); err != nil {
+			return nil, err
+		}
+		history[sn] = append(history[sn], timedValue{since: since, value: parse(value)})
+	}
+	return history, rows.Err()
+}
+
+func parseInventoryNumber(value string) float64 {
+	n, _ := strconv.ParseFloat(value, 64)
+	return n
+}
+
+// valueAt is the value in force at t. Readings from before the setting was
+// first recorded use the earliest one.
+func valueAt(history []timedValue, t time.Time) float64 {
+	if len(history) == 0 {
+		return 0
+	}
+	value := history[0].value
+	for _, v := range history {
+		if v.since.After(t) {
+			break
+		}
+		value = v.value
+	}
+	return value
+}
+
+// arrayShares is the part of the station's array each inverter is assumed to
+// carry, in proportion to its rating, or evenly when ratings are unknown.
+func arrayShares(samples []inverterSample) map[string]float64 {
+	ratings := make(map[string]float64)
+	for _, s := range samples {
+		ratings[s.SN] = s.Capacity
+	}
+	var total float64
+	for _, rating := range ratings {
+		total += rating
+	}
+	shares := make(map[string]float64, len(ratings))
+	for sn, rating := range ratings {
+		if total > 0 {
+			shares[sn] = rating / total
+		} else {
+			shares[sn] = 1 / float64(len(ratings))
+		}
+	}
+	return shares
+}
+
+// sampleLimit is the lower of the inverter's rating and its export limit,
+// and which of the two it is.
+func sampleLimit(s inverterSample, exportWatts float64) (float64, string) {
+	limit, name := s.Capacity*1000, "capacity"
+	if exportWatts > 0 && (limit <= 0 || exportWatts < limit) {
+		limit, name = exportWatts, "export_limit"
+	}
+	return limit, name
+}
+
+// cloudEstimate is the cloud cover at a reading: from the measured
+// irradiance against the clear sky when the provider has it, otherwise as
+// observed.
+func cloudEstimate(s inverterSample) (float64, bool) {
+	if s.GHI > 0 && s.ClearSky > 0 && s.Capacity > 0 {
+		return math.Max(0, math.Min(100, 100*(1-s.Capacity*s.GHI/s.ClearSky))), true
+	}
+	if s.Cloud.Valid {
+		return float64(s.Cloud.Int64), true
+	}
+	return 0, false
+}
+
+// detectClipping looks, on sunny days, for stretches where an inverter's
+// output flatlines at its rating or export limit, and estimates the energy
+// lost as what the weather allowed for its share of the array, at the
+// inverter's usual performance ratio, above what it produced. stationKW is
+// the history of the station's capacity, the DC size of the array.
+func detectClipping(samples []inverterSample, limits map[string][]timedValue, stationKW []timedValue, loc *time.Location, maxCloud float64) []ClippingDayV2 {
+	days := []ClippingDayV2{}
+	shares := arrayShares(samples)
+	for start := 0; start < len(samples); {
+		end := start
+		for end < len(samples) && samples[end].SN == samples[start].SN {
+			end++
+		}
+		inverter := samples[start:end]
+		start = end
+
+		atLimit := make([]bool, len(inverter))
+		var unclipped []inverterSample
+		for i := range inverter {
+			inverter[i].Array = valueAt(stationKW, inverter[i].Time) * shares[inverter[i].SN]
+			s := inverter[i]
+			limit, _ := sampleLimit(s, valueAt(limits[s.SN], s.Time))
+			atLimit[i] = limit > 0 && s.Output >= (1-clipTolerance)*limit
+			if !atLimit[i] {
+				unclipped = append(unclipped, s)
+			}
+		}
+		// An oversized array can make more than the rating, so the ratio is
+		// not capped at 1 as it is for derating.
+		ratio := medianRatio(unclipped, math.Inf(1))
+
+		for first := 0; first < len(inverter); {
+			date := inverter[first].Time.In(loc).Format(dateLayout)
+			last := first
+			for last+1 < len(inverter) && inverter[last+1].Time.In(loc).Format(dateLayout) == date {
+				last++
+			}
+			if day, ok := clippingDay(inverter, atLimit, first, last, ratio, limits[inverter[first].SN], maxCloud); ok {
+				day.Date = date
+				days = append(days, day)
+			}
+			first = last + 1
+		}
+	}
+	sort.SliceStable(days, func(i, j int) bool { return days[i].Date < days[j].Date })
+	return days
+}
+
+// clippingDay analyses inverter[first:last+1], the readings of one local
+// day. Days without daylight readings are skipped.
+func clippingDay(inverter []inverterSample, atLimit []bool, first int, last int, ratio float64, limits []timedValue, maxCloud float64) (ClippingDayV2, bool) {
+	day := ClippingDayV2{
+		SerialNumber: inverter[first].SN,
+		Name:         inverter[last].Name,
+		CapacityW:    inverter[last].Capacity * 1000,
+		ArrayKW:      inverter[last].Array,
+		ExportLimitW: valueAt(limits, inverter[last].Time),
+		Periods:      []ClippingPeriodV2{},
+	}
+	var cloud, clouds float64
+	daylight := false
+	for i := first; i <= last; i++ {
+		s := inverter[i]
+		daylight = daylight || s.potential() > 0
+		day.PeakOutputW = math.Max(day.PeakOutputW, s.Output)
+		day.EnergyKWh += s.Output * sampleDuration(inverter, i).Hours() / 1000
+		if c, ok := cloudEstimate(s); ok && s.potential() > 0 {
+			cloud += c
+			clouds++
+		}
+	}
+	if !daylight {
+		return day, false
+	}
+	if clouds > 0 {
+		mean := cloud / clouds
+		day.CloudCoverPercent = &mean
+		day.Sunny = mean <= maxCloud
+	}
+	if !day.Sunny {
+		return day, true
+	}
+	for i := first; i <= last; i++ {
+		if !atLimit[i] {
+			continue
+		}
+		j := i
+		for j+1 <= last && atLimit[j+1] && inverter[j+1].Time.Sub(inverter[j].Time) <= sampleGap {
+			j++
+		}
+		if j-i+1 >= minClipReadings {
+			limit, name := sampleLimit(inverter[i], valueAt(limits, inverter[i].Time))
+			period := ClippingPeriodV2{Start: inverter[i].Time, End: inverter[j].Time, Limit: name, LimitW: limit}
+			for k := i; k <= j; k++ {
+				s := inverter[k]
+				duration := sampleDuration(inverter, k)
+				period.MeanOutputW += s.Output / float64(j-i+1)
+				period.LostKWh += math.Max(0, ratio*s.potential()-s.Output) * duration.Hours() / 1000
+				day.ClippedMinutes += duration.Minutes()
+			}
+			day.LostKWh += period.LostKWh
+			day.Periods = append(day.Periods, period)
+		}
+		i = j
+	}
+	return day, true
+}
+
+func clippingHandler(configs *ConfigSource, store *Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if store == nil {
+			writeJSON(w, http.StatusServiceUnavailable, ErrorV2{Error: "clipping analysis needs database.dsn to be configured"})
+			return
+		}
+		from, to, ok := analysisRange(w, r, maxAnalysisDays)
+		if !ok {
+			return
+		}
+		maxCloud, ok := floatParam(w, r, "max_cloud_percent", 30, 0, 100)
+		if !ok {
+			return
+		}
+		config := configs.Get()
+		stationID := orDefault(r.URL.Query().Get("station"), config.ClientConfig.StationInfo.StationID)
+		samples, err := store.inverterSamples(stationID, from, to)
+		if err != nil {
+			writeJSON(w, http.StatusInternalServerError, ErrorV2{Error: err.Error()})
+			return
+		}
+		limits, err := store.inventoryHistory(stationID, "export_power_limit", parseExportLimit)
+		if err != nil {
+			writeJSON(w, http.StatusInternalServerError, ErrorV2{Error: err.Error()})
+			return
+		}
+		capacities, err := store.inventoryHistory(stationID, "capacity_kw", parseInventoryNumber)
+		if err != nil {
+			writeJSON(w, http.StatusInternalServerError, ErrorV2{Error: err.Error()})
+			return
+		}
+		report := ClippingReportV2{
+			StationID:       stationID,
+			From:            from,
+			To:              to,
+			MaxCloudPercent: maxCloud,
+			Days:            detectClipping(samples, limits, capacities[""], reportLocation(config), maxCloud),
+		}
+		for _, day := range report.Days {
+			report.LostKWh += day.LostKWh
+		}
+		writeJSON(w, http.StatusOK, report)
+	}
+}
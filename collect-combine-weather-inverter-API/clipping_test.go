@@ -0,0 +1,68 @@
+package main
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestDetectClippingOversizedArray(t *testing.T) {
+	const (
+		ratingKW = 5.0
+		ratio    = 0.8
+	)
+	dawn := time.Date(2026, 1, 10, 6, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name    string
+		arrayKW float64
+	}{
+		{"array matches rating", 5},
+		{"array 1.3 times rating", 6.5},
+		{"array 1.6 times rating", 8},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var samples []inverterSample
+			var lostKWh float64
+			for minutes := 0; minutes <= 12*60; minutes += 5 {
+				ghi := 1000 * math.Sin(math.Pi*float64(minutes)/(12*60))
+				unclipped := ratio * test.arrayKW * ghi
+				output := math.Min(unclipped, ratingKW*1000)
+				lostKWh += (unclipped - output) * 5 / 60 / 1000
+				samples = append(samples, inverterSample{
+					SN:       "INV1",
+					Time:     dawn.Add(time.Duration(minutes) * time.Minute),
+					Status:   1,
+					Output:   output,
+					Capacity: ratingKW,
+					ClearSky: ratingKW * ghi,
+					GHI:      ghi,
+				})
+			}
+			stationKW := []timedValue{{since: dawn, value: test.arrayKW}}
+			days := detectClipping(samples, nil, stationKW, time.UTC, 30)
+			if len(days) != 1 {
+				t.Fatalf("got %d days, want 1", len(days))
+			}
+			day := days[0]
+			if !day.Sunny {
+				t.Fatalf("day not sunny, cloud %v", day.CloudCoverPercent)
+			}
+			if day.ArrayKW != test.arrayKW {
+				t.Errorf("array = %v kW, want %v", day.ArrayKW, test.arrayKW)
+			}
+			if lostKWh == 0 {
+				if len(day.Periods) != 0 || day.LostKWh != 0 {
+					t.Errorf("got %d periods and %.3f kWh lost, want none", len(day.Periods), day.LostKWh)
+				}
+				return
+			}
+			if len(day.Periods) != 1 || day.Periods[0].Limit != "capacity" {
+				t.Fatalf("got periods %+v, want one at capacity", day.Periods)
+			}
+			if math.Abs(day.LostKWh-lostKWh) > 0.01*lostKWh {
+				t.Errorf("lost %.3f kWh, want %.3f", day.LostKWh, lostKWh)
+			}
+		})
+	}
+}
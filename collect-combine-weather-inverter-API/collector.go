@@ -0,0 +1,292 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Snapshot is one poll of SEMS and the weather provider for a station.
+type Snapshot struct {
+	StationID    string
+	CollectedAt  time.Time
+	Location     *time.Location `json:"-"`
+	InverterData InverterData
+	Weather      Weather
+	Readings     []Reading
+}
+
+// Reading is the state of a single inverter within a snapshot. Power is in W,
+// energy in kWh and all times are UTC.
+type Reading struct {
+	InverterSN   string
+	InverterName string
+	Capacity     float64
+	OutputPower  float64
+	EnergyDay    float64
+	EnergyMonth  float64
+	EnergyTotal  float64
+	ReadTime     time.Time
+	BootTime     time.Time
+	Performance  PVPerformance
+	Status       int
+	WorkHours    float64
+	Vpv          [4]float64
+	Ipv          [4]float64
+	Vac          [3]float64
+	Iac          [3]float64
+	Fac          [3]float64
+	Temperature  *float64
+}
+
+func collectSnapshot(config Config) (Snapshot, error) {
+	loginResponse, err := runLoginRequest(config)
+	if err != nil {
+		return Snapshot{}, err
+	}
+	inverterData, err := getInverterData(config, loginResponse)
+	if err != nil {
+		return Snapshot{}, err
+	}
+	if len(inverterData.Data.Inverter) == 0 {
+		return Snapshot{}, fmt.Errorf("station %s reported no inverters", config.ClientConfig.StationInfo.StationID)
+	}
+	info := inverterData.Data.Info
+	weather, err := getWeatherData(config, info.Latitude, info.Longitude)
+	if err != nil {
+		return Snapshot{}, err
+	}
+	now := time.Now().UTC()
+	loc, err := stationLocation(config, weather, inverterData, now)
+	if err != nil {
+		return Snapshot{}, err
+	}
+	if weather.Sunrise == 0 && weather.Sunset == 0 {
+		weather.Sunrise, weather.Sunset = sunriseSunset(now.In(loc), info.Latitude, info.Longitude)
+	}
+	if weather.ObservedAt == 0 {
+		weather.ObservedAt = now.Unix()
+	}
+
+	snapshot := Snapshot{
+		StationID:    config.ClientConfig.StationInfo.StationID,
+		CollectedAt:  now,
+		Location:     loc,
+		InverterData: inverterData,
+		Weather:      weather,
+	}
+	for _, inverter := range inverterData.Data.Inverter {
+		capacity := inverter.Capacity
+		if capacity <= 0 {
+			capacity = stationCapacity(inverterData)
+		}
+		// 0 °C is a real reading, so a temperature is only missing when SEMS
+		// leaves the field out or the inverter is offline and zero-filled.
+		temperature := inverter.Tempperature
+		if temperature == nil {
+			temperature = inverter.InvertFull.Tempperature
+		}
+		if inverter.Status == -1 {
+			temperature = nil
+		}
+		readTime := parseSEMSTime(inverter.Time, info.DateFormat, loc)
+		if readTime.IsZero() {
+			readTime = parseSEMSTime(inverter.LastRefreshTime, info.DateFormat, loc)
+		}
+		intervalEnergy, _ := intervals.energy(snapshot.StationID+"/"+inverter.Sn, readTime, inverter.Eday)
+		snapshot.Readings = append(snapshot.Readings, Reading{
+			InverterSN:   inverter.Sn,
+			InverterName: inverter.Name,
+			Capacity:     capacity,
+			OutputPower:  inverter.D.Pac,
+			EnergyDay:    inverter.Eday,
+			EnergyMonth:  inverter.Emonth,
+			EnergyTotal:  inverter.Etotal,
+			ReadTime:     readTime,
+			BootTime:     parseSEMSTime(inverter.TurnonTime, info.DateFormat, loc),
+			Performance:  pvPerformance(now, info.Latitude, info.Longitude, capacity, inverter.D.Pac, intervalEnergy, weather.GHI),
+			Status:       inverter.Status,
+			WorkHours:    inverter.D.HTotal,
+			Vpv:          [4]float64{inverter.D.Vpv1, inverter.D.Vpv2, inverter.D.Vpv3, inverter.D.Vpv4},
+			Ipv:          [4]float64{inverter.D.Ipv1, inverter.D.Ipv2, inverter.D.Ipv3, inverter.D.Ipv4},
+			Vac:          [3]float64{inverter.D.Vac1, inverter.D.Vac2, inverter.D.Vac3},
+			Iac:          [3]float64{inverter.D.Iac1, inverter.D.Iac2, inverter.D.Iac3},
+			Fac:          [3]float64{inverter.D.Fac1, inverter.D.Fac2, inverter.D.Fac3},
+			Temperature:  temperature,
+		})
+	}
+	return snapshot, nil
+}
+
+// Collector polls on an interval and stores each snapshot. Readings whose
+// SEMS read time has not moved since the last poll are skipped, or with
+// collector.duplicates set to "upsert" overwrite the stored row. Snapshots
+// that cannot be stored are kept in the buffer, when configured, and replayed
+// in order once the database is back. New snapshots are also published to
+// the /stream clients; without a database the collector only polls while
+// someone is listening. The config is re-read on every poll so reloads apply
+// from the next one.
+type Collector struct {
+	configs  *ConfigSource
+	store    *Store
+	buffer   *Buffer
+	hub      *Hub
+	mu       sync.Mutex
+	lastRead map[string]time.Time
+}
+
+func newCollector(configs *ConfigSource, store *Store, buffer *Buffer, hub *Hub) *Collector {
+	return &Collector{
+		configs:  configs,
+		store:    store,
+		buffer:   buffer,
+		hub:      hub,
+		lastRead: make(map[string]time.Time),
+	}
+}
+
+// run polls until ctx is cancelled. A poll in progress is completed, and
+// buffered snapshots are flushed to the database, before it returns.
+func (c *Collector) run(ctx context.Context) {
+	interval := c.interval()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if err := c.collectOnce(); err != nil {
+			metrics.collectErrors.Add(1)
+			logger.Error("collect failed", "err", err)
+		}
+		if next := c.interval(); next != interval {
+			interval = next
+			ticker.Reset(interval)
+		}
+		select {
+		case <-ctx.Done():
+			c.flush()
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (c *Collector) flush() {
+	if c.buffer == nil || c.buffer.Len() == 0 {
+		return
+	}
+	if err := c.buffer.replay(c.save); err != nil {
+		logger.Warn("buffer not flushed, kept on disk", "queued", c.buffer.Len(), "err", err)
+		return
+	}
+	logger.Info("buffer flushed")
+}
+
+func (c *Collector) interval() time.Duration {
+	interval := time.Duration(c.configs.Get().Collector.IntervalSeconds) * time.Second
+	if interval <= 0 {
+		interval = time.Minute
+	}
+	return interval
+}
+
+func (c *Collector) collectOnce() error {
+	if c.store == nil && c.hub.listeners() == 0 {
+		return nil
+	}
+	if c.buffer != nil {
+		if err := c.buffer.replay(c.save); err != nil {
+			logger.Warn("replay buffer failed", "queued", c.buffer.Len(), "err", err)
+		}
+	}
+	config := c.configs.Get()
+	snapshot, err := collectSnapshot(config)
+	if err != nil {
+		return err
+	}
+	snapshot.Readings = dropUntimed(snapshot)
+	if config.Collector.Duplicates != "upsert" {
+		snapshot.Readings = c.suppressDuplicates(snapshot)
+	}
+	logger.Debug("collected snapshot", "station", snapshot.StationID, "new_readings", len(snapshot.Readings))
+	if len(snapshot.Readings) > 0 {
+		c.hub.publish(snapshot)
+	}
+	if c.store == nil {
+		c.remember(snapshot)
+		return nil
+	}
+	// Snapshots without new readings are still saved for the station
+	// counters and the equipment inventory.
+	stored := newStoredSnapshot(snapshot)
+	if c.buffer == nil {
+		if err := c.save(stored); err != nil {
+			return err
+		}
+		c.remember(snapshot)
+		return nil
+	}
+	if c.buffer.Len() == 0 {
+		if err = c.save(stored); err == nil {
+			c.remember(snapshot)
+			return nil
+		}
+	}
+	if bufferErr := c.buffer.append(stored); bufferErr != nil {
+		return fmt.Errorf("store: %v; buffer: %v", err, bufferErr)
+	}
+	c.remember(snapshot)
+	if err != nil {
+		return fmt.Errorf("store failed, snapshot buffered: %v", err)
+	}
+	return nil
+}
+
+func (c *Collector) save(snapshot storedSnapshot) error {
+	duplicates, err := c.store.saveSnapshot(snapshot, c.configs.Get().Collector.Duplicates == "upsert")
+	if err != nil {
+		return err
+	}
+	metrics.readingsStored.Add(int64(len(snapshot.Readings) - duplicates))
+	metrics.duplicatesSuppressed.Add(int64(duplicates))
+	return nil
+}
+
+func (c *Collector) suppressDuplicates(snapshot Snapshot) []Reading {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var fresh []Reading
+	for _, reading := range snapshot.Readings {
+		key := snapshot.StationID + "/" + reading.InverterSN
+		if last, ok := c.lastRead[key]; ok && last.Equal(reading.ReadTime) {
+			metrics.duplicatesSuppressed.Add(1)
+			continue
+		}
+		fresh = append(fresh, reading)
+	}
+	return fresh
+}
+
+// remember records the read times of a snapshot once it has been saved or
+// buffered, so later polls of the same readings are suppressed.
+func (c *Collector) remember(snapshot Snapshot) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, reading := range snapshot.Readings {
+		c.lastRead[snapshot.StationID+"/"+reading.InverterSN] = reading.ReadTime
+	}
+}
+
+// dropUntimed leaves out readings whose read time SEMS did not report in a
+// form we can parse; stored with a zero read time they would clash with each
+// other under the unique key.
+func dropUntimed(snapshot Snapshot) []Reading {
+	var timed []Reading
+	for _, reading := range snapshot.Readings {
+		if reading.ReadTime.IsZero() {
+			logger.Warn("skipping reading without a read time", "station", snapshot.StationID, "inverter", reading.InverterSN)
+			continue
+		}
+		timed = append(timed, reading)
+	}
+	return timed
+}
@@ -0,0 +1,320 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+var configPath = "config.json"
+
+// envPrefix starts the environment variables that override config fields.
+const envPrefix = "SOLAR"
+
+// ConfigSource holds the active config. Readers take a copy per request or
+// poll; a reload swaps the pointer, so work already in flight finishes on
+// the config it started with.
+type ConfigSource struct {
+	path    string
+	current atomic.Pointer[Config]
+}
+
+func loadConfigSource(path string) (*ConfigSource, error) {
+	config, err := loadConfig(path)
+	if err != nil {
+		return nil, err
+	}
+	s := &ConfigSource{path: path}
+	s.current.Store(&config)
+	return s, nil
+}
+
+func (s *ConfigSource) Get() Config {
+	return *s.current.Load()
+}
+
+// reload keeps the running config when the file does not load or validate.
+func (s *ConfigSource) reload() {
+	config, err := loadConfig(s.path)
+	if err != nil {
+		logger.Error("config reload failed, keeping previous config", "err", err)
+		return
+	}
+	previous := s.Get()
+	if config.Database != previous.Database || config.Collector.BufferPath != previous.Collector.BufferPath ||
+		config.Collector.BufferMaxEntries != previous.Collector.BufferMaxEntries || config.Server != previous.Server {
+		logger.Warn("config reload: database, buffer and server settings take effect after a restart")
+	}
+	s.current.Store(&config)
+	logger.Info("config reloaded", "path", s.path)
+}
+
+// watch reloads on SIGHUP and whenever the file changes. The directory is
+// watched rather than the file so editors and config maps that replace the
+// file by rename are picked up.
+func (s *ConfigSource) watch() {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+
+	var events chan fsnotify.Event
+	watcher, err := fsnotify.NewWatcher()
+	if err == nil {
+		err = watcher.Add(filepath.Dir(s.path))
+	}
+	if err != nil {
+		logger.Warn("config: not watching for changes", "path", s.path, "err", err)
+	} else {
+		events = watcher.Events
+		go func() {
+			for err := range watcher.Errors {
+				logger.Warn("config watch", "err", err)
+			}
+		}()
+	}
+
+	// Writes usually arrive as several events; wait for them to settle.
+	debounce := time.NewTimer(time.Hour)
+	debounce.Stop()
+	name := filepath.Clean(s.path)
+	for {
+		select {
+		case <-hup:
+			s.reload()
+		case event := <-events:
+			if filepath.Clean(event.Name) == name && event.Op&(fsnotify.Write|fsnotify.Create|fsnotify.Rename) != 0 {
+				debounce.Reset(500 * time.Millisecond)
+			}
+		case <-debounce.C:
+			s.reload()
+		}
+	}
+}
+
+// importConfig loads the config for one-shot commands and exits when it is
+// invalid.
+func importConfig() Config {
+	config, err := loadConfig(configPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return config
+}
+
+func loadConfig(path string) (Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Config{}, err
+	}
+	data, err = configJSON(path, data)
+	if err != nil {
+		return Config{}, fmt.Errorf("%s: %v", path, err)
+	}
+	var config Config
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&config); err != nil {
+		return Config{}, fmt.Errorf("%s: %v", path, err)
+	}
+	if decoder.More() {
+		return Config{}, fmt.Errorf("%s: unexpected data after the config object", path)
+	}
+	if err := applyEnvOverrides(reflect.ValueOf(&config).Elem(), envPrefix); err != nil {
+		return Config{}, err
+	}
+	config.Auth.resolveSecrets()
+	if err := config.validate(); err != nil {
+		return Config{}, fmt.Errorf("%s: %v", path, err)
+	}
+	return config, nil
+}
+
+// validate reports every problem at once so a broken file can be fixed in
+// one pass.
+func (config Config) validate() error {
+	var problems []string
+	require := func(value string, field string) {
+		if strings.TrimSpace(value) == "" {
+			problems = append(problems, field+" is required")
+		}
+	}
+	checkURL := func(value string, field string) {
+		if value == "" {
+			return
+		}
+		if u, err := url.Parse(value); err != nil || u.Scheme == "" || u.Host == "" {
+			problems = append(problems, fmt.Sprintf("%s %q is not an absolute URL", field, value))
+		}
+	}
+
+	require(config.APIConfig.BaseURL, "apiConfig.baseURL")
+	checkURL(config.APIConfig.BaseURL, "apiConfig.baseURL")
+	require(config.APIConfig.LoginURL, "apiConfig.loginURL")
+	require(config.APIConfig.InverterURL, "apiConfig.inverterURL")
+	require(config.ClientConfig.LoginInfo.Account, "clientConfig.loginInfo.account")
+	require(config.ClientConfig.LoginInfo.Password, "clientConfig.loginInfo.pwd")
+	require(config.ClientConfig.StationInfo.StationID, "clientConfig.stationInfo.powerStationId")
+	if name := config.ClientConfig.Timezone; name != "" {
+		if _, err := time.LoadLocation(name); err != nil {
+			problems = append(problems, fmt.Sprintf("clientConfig.timezone %q: %v", name, err))
+		}
+	}
+
+	api := config.WeatherAPI
+	provider := strings.ToLower(api.Provider)
+	if provider == "" {
+		provider = "openweathermap"
+	}
+	if _, ok := weatherProviders[provider]; !ok {
+		problems = append(problems, fmt.Sprintf("weatherAPI.provider %q is not one of openweathermap, openmeteo, bom, metno", api.Provider))
+	}
+	switch provider {
+	case "openweathermap":
+		require(api.AppID, "weatherAPI.appid")
+		require(api.BaseURL, "weatherAPI.baseURL")
+		checkURL(api.BaseURL, "weatherAPI.baseURL")
+	case "openmeteo":
+		checkURL(api.OpenMeteo.BaseURL, "weatherAPI.openMeteo.baseURL")
+	case "bom":
+		require(api.BOM.ProductID, "weatherAPI.bom.productID")
+		require(api.BOM.StationID, "weatherAPI.bom.stationID")
+		checkURL(api.BOM.BaseURL, "weatherAPI.bom.baseURL")
+	case "metno":
+		checkURL(api.MetNo.BaseURL, "weatherAPI.metNo.baseURL")
+	}
+	if api.Latitude < -90 || api.Latitude > 90 {
+		problems = append(problems, fmt.Sprintf("weatherAPI.latitude %v is out of range", api.Latitude))
+	}
+	if api.Longitude < -180 || api.Longitude > 180 {
+		problems = append(problems, fmt.Sprintf("weatherAPI.longitude %v is out of range", api.Longitude))
+	}
+
+	collector := config.Collector
+	if collector.IntervalSeconds < 0 {
+		problems = append(problems, "collector.intervalSeconds must not be negative")
+	}
+	if collector.Duplicates != "" && collector.Duplicates != "skip" && collector.Duplicates != "upsert" {
+		problems = append(problems, fmt.Sprintf("collector.duplicates %q is not skip or upsert", collector.Duplicates))
+	}
+	if collector.BufferMaxEntries < 0 {
+		problems = append(problems, "collector.bufferMaxEntries must not be negative")
+	}
+
+	problems = append(problems, config.Auth.validate()...)
+	problems = append(problems, config.Tariff.validate()...)
+
+	server := config.Server
+	if !server.TLSSelfSigned && (server.TLSCertFile == "") != (server.TLSKeyFile == "") {
+		problems = append(problems, "server.tlsCertFile and server.tlsKeyFile must be set together")
+	}
+	if server.TLSSelfSigned && (server.TLSCertFile == "") != (server.TLSKeyFile == "") {
+		problems = append(problems, "server.tlsSelfSigned needs both or neither of server.tlsCertFile and server.tlsKeyFile")
+	}
+	if server.ReadTimeoutSeconds < 0 || server.WriteTimeoutSeconds < 0 || server.ShutdownTimeoutSeconds < 0 {
+		problems = append(problems, "server timeouts must not be negative")
+	}
+
+	if len(problems) == 0 {
+		return nil
+	}
+	return fmt.Errorf("invalid config:\n  %s", strings.Join(problems, "\n  "))
+}
+
+// applyEnvOverrides sets every config field from an environment variable
+// named after its JSON path, e.g. SOLAR_CLIENTCONFIG_LOGININFO_PWD for
+// clientConfig.loginInfo.pwd. The same name with a _FILE suffix reads the
+// value from a file instead, for Docker and Kubernetes secrets.
+func applyEnvOverrides(v reflect.Value, prefix string) error {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		key := strings.Split(field.Tag.Get("json"), ",")[0]
+		if key == "" || key == "-" {
+			continue
+		}
+		name := prefix + "_" + strings.ToUpper(key)
+		value := v.Field(i)
+		if value.Kind() == reflect.Struct {
+			if err := applyEnvOverrides(value, name); err != nil {
+				return err
+			}
+			continue
+		}
+		raw, ok, err := lookupEnv(name)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			continue
+		}
+		if err := setField(value, raw); err != nil {
+			return fmt.Errorf("%s: %v", name, err)
+		}
+	}
+	return nil
+}
+
+// lookupEnv prefers NAME over NAME_FILE. A trailing newline in the file is
+// dropped since most tools that write secrets add one.
+func lookupEnv(name string) (string, bool, error) {
+	if value, ok := os.LookupEnv(name); ok {
+		return value, true, nil
+	}
+	path, ok := os.LookupEnv(name + "_FILE")
+	if !ok {
+		return "", false, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", false, fmt.Errorf("%s_FILE: %v", name, err)
+	}
+	return strings.TrimRight(string(data), "\r\n"), true, nil
+}
+
+func setField(value reflect.Value, raw string) error {
+	switch value.Kind() {
+	case reflect.String:
+		value.SetString(raw)
+	case reflect.Int, reflect.Int64:
+		n, err := strconv.ParseInt(strings.TrimSpace(raw), 10, 64)
+		if err != nil {
+			return err
+		}
+		value.SetInt(n)
+	case reflect.Float64:
+		f, err := strconv.ParseFloat(strings.TrimSpace(raw), 64)
+		if err != nil {
+			return err
+		}
+		value.SetFloat(f)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(strings.TrimSpace(raw))
+		if err != nil {
+			return err
+		}
+		value.SetBool(b)
+	case reflect.Slice, reflect.Map:
+		// Lists are given as JSON, e.g.
+		// SOLAR_AUTH_APIKEYS='[{"name":"grafana","key":"..."}]'.
+		fresh := reflect.New(value.Type())
+		if err := json.Unmarshal([]byte(raw), fresh.Interface()); err != nil {
+			return fmt.Errorf("expected JSON: %v", err)
+		}
+		value.Set(fresh.Elem())
+	default:
+		return fmt.Errorf("cannot be set from the environment")
+	}
+	return nil
+}
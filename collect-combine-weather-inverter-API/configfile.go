@@ -0,0 +1,256 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// configJSON converts YAML and TOML config files to JSON so every format is
+// decoded, and checked for unknown keys, by the same json tags.
+func configJSON(path string, data []byte) ([]byte, error) {
+	var doc interface{}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		if err := yaml.Unmarshal(data, &doc); err != nil {
+			return nil, err
+		}
+	case ".toml":
+		var table map[string]interface{}
+		if _, err := toml.Decode(string(data), &table); err != nil {
+			return nil, err
+		}
+		doc = table
+	default:
+		return data, nil
+	}
+	if doc == nil {
+		doc = map[string]interface{}{}
+	}
+	return json.Marshal(doc)
+}
+
+// defaultConfig is the reference config printed by config print-default.
+func defaultConfig() Config {
+	return Config{
+		APIConfig: APIConfig{
+			BaseURL:        "https://www.semsportal.com",
+			LoginURL:       "/api/v2/Common/CrossLogin",
+			InverterURL:    "/api/v2/PowerStation/GetMonitorDetailByPowerstationId",
+			PowerChartURL:  "/api/v2/Charts/GetPlantPowerChart",
+			EnergyChartURL: "/api/v2/Charts/GetChartByPlant",
+			LoginToken:     LoginToken{Version: "v2.1.0", Client: "ios", Language: "en"},
+		},
+		WeatherAPI: WeatherAPI{
+			Provider:    "openweathermap",
+			BaseURL:     "https://api.openweathermap.org/data/2.5/weather?",
+			CountryCode: "au",
+			OpenMeteo:   OpenMeteoConfig{BaseURL: "https://api.open-meteo.com/v1/forecast"},
+			BOM:         BOMConfig{BaseURL: "http://www.bom.gov.au/fwo/"},
+			MetNo:       MetNoConfig{BaseURL: "https://api.met.no/weatherapi/locationforecast/2.0/compact"},
+		},
+		Collector: CollectorConfig{
+			IntervalSeconds:  60,
+			Duplicates:       "skip",
+			BufferPath:       "buffer.jsonl",
+			BufferMaxEntries: 10080,
+		},
+		Server: ServerConfig{
+			Listen:                 ":22222",
+			ReadTimeoutSeconds:     30,
+			WriteTimeoutSeconds:    300,
+			ShutdownTimeoutSeconds: 30,
+		},
+	}
+}
+
+func runConfigCommand(args []string) {
+	if len(args) == 0 || args[0] != "print-default" {
+		log.Fatal("usage: config print-default [--format yaml|toml|json]")
+	}
+	flags := flag.NewFlagSet("config print-default", flag.ExitOnError)
+	format := flags.String("format", "yaml", "yaml, toml or json (json cannot carry comments)")
+	flags.Parse(args[1:])
+	if err := writeReferenceConfig(os.Stdout, *format); err != nil {
+		log.Fatalf("config print-default: %v", err)
+	}
+}
+
+func writeReferenceConfig(w io.Writer, format string) error {
+	config := reflect.ValueOf(defaultConfig())
+	out := bufio.NewWriter(w)
+	switch format {
+	case "yaml":
+		fmt.Fprintln(out, "# collect-combine-weather-inverter-API reference config.")
+		fmt.Fprintln(out, "# Every field can also be set with SOLAR_<PATH> or SOLAR_<PATH>_FILE.")
+		writeYAML(out, config, "")
+	case "toml":
+		fmt.Fprintln(out, "# collect-combine-weather-inverter-API reference config.")
+		fmt.Fprintln(out, "# Every field can also be set with SOLAR_<PATH> or SOLAR_<PATH>_FILE.")
+		writeTOML(out, config, "")
+	case "json":
+		data, err := json.MarshalIndent(config.Interface(), "", "    ")
+		if err != nil {
+			return err
+		}
+		out.Write(append(data, '\n'))
+	default:
+		return fmt.Errorf("unknown format %q", format)
+	}
+	return out.Flush()
+}
+
+type configField struct {
+	key   string
+	doc   string
+	value reflect.Value
+}
+
+func configFields(v reflect.Value) []configField {
+	var fields []configField
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		key := strings.Split(t.Field(i).Tag.Get("json"), ",")[0]
+		if key == "" || key == "-" {
+			continue
+		}
+		fields = append(fields, configField{key: key, doc: t.Field(i).Tag.Get("doc"), value: v.Field(i)})
+	}
+	return fields
+}
+
+func isTable(v reflect.Value) bool {
+	return v.Kind() == reflect.Struct || isTableList(v) && v.Len() > 0
+}
+
+func isTableList(v reflect.Value) bool {
+	return v.Kind() == reflect.Slice && v.Type().Elem().Kind() == reflect.Struct
+}
+
+// exampleList stands in for an empty list of tables: one zero element, written
+// commented out so the docs of its fields still appear.
+func exampleList(t reflect.Type) reflect.Value {
+	return reflect.MakeSlice(t, 1, 1)
+}
+
+func commentOut(out io.Writer, indent string, text string) {
+	for _, line := range strings.Split(strings.TrimSuffix(text, "\n"), "\n") {
+		fmt.Fprintf(out, "%s# %s\n", indent, strings.TrimPrefix(line, indent))
+	}
+}
+
+func writeComment(out io.Writer, indent string, doc string) {
+	if doc != "" {
+		fmt.Fprintf(out, "%s# %s\n", indent, doc)
+	}
+}
+
+func writeYAML(out io.Writer, v reflect.Value, indent string) {
+	for _, field := range configFields(v) {
+		writeComment(out, indent, field.doc)
+		switch {
+		case field.value.Kind() == reflect.Struct:
+			fmt.Fprintf(out, "%s%s:\n", indent, field.key)
+			writeYAML(out, field.value, indent+"  ")
+		case isTable(field.value):
+			writeYAMLList(out, field.key, field.value, indent)
+		case isTableList(field.value):
+			var example strings.Builder
+			writeYAMLList(&example, field.key, exampleList(field.value.Type()), indent)
+			commentOut(out, indent, example.String())
+		default:
+			fmt.Fprintf(out, "%s%s: %s\n", indent, field.key, scalarLiteral(field.value))
+		}
+	}
+}
+
+func writeYAMLList(out io.Writer, key string, list reflect.Value, indent string) {
+	fmt.Fprintf(out, "%s%s:\n", indent, key)
+	for i := 0; i < list.Len(); i++ {
+		var item strings.Builder
+		writeYAML(&item, list.Index(i), indent+"    ")
+		fmt.Fprintf(out, "%s  - %s", indent, strings.TrimPrefix(item.String(), indent+"    "))
+	}
+}
+
+// writeTOML writes the scalar keys of a table before its subtables, as TOML
+// requires.
+func writeTOML(out io.Writer, v reflect.Value, path string) {
+	fields := configFields(v)
+	for _, field := range fields {
+		if !isTableList(field.value) && field.value.Kind() != reflect.Struct {
+			writeComment(out, "", field.doc)
+			fmt.Fprintf(out, "%s = %s\n", field.key, scalarLiteral(field.value))
+		}
+	}
+	for _, field := range fields {
+		if !isTableList(field.value) && field.value.Kind() != reflect.Struct {
+			continue
+		}
+		name := field.key
+		if path != "" {
+			name = path + "." + field.key
+		}
+		if field.value.Kind() == reflect.Struct {
+			fmt.Fprintln(out)
+			writeComment(out, "", field.doc)
+			fmt.Fprintf(out, "[%s]\n", name)
+			writeTOML(out, field.value, name)
+			continue
+		}
+		if field.value.Len() == 0 {
+			var example strings.Builder
+			fmt.Fprintf(&example, "[[%s]]\n", name)
+			writeTOML(&example, exampleList(field.value.Type()).Index(0), name)
+			fmt.Fprintln(out)
+			writeComment(out, "", field.doc)
+			commentOut(out, "", example.String())
+			continue
+		}
+		for i := 0; i < field.value.Len(); i++ {
+			fmt.Fprintln(out)
+			if i == 0 {
+				writeComment(out, "", field.doc)
+			}
+			fmt.Fprintf(out, "[[%s]]\n", name)
+			writeTOML(out, field.value.Index(i), name)
+		}
+	}
+}
+
+// scalarLiteral formats a value the same way in YAML and TOML: double
+// quoted strings, bare numbers and booleans, inline lists.
+func scalarLiteral(v reflect.Value) string {
+	switch v.Kind() {
+	case reflect.String:
+		return strconv.Quote(v.String())
+	case reflect.Int, reflect.Int64:
+		return strconv.FormatInt(v.Int(), 10)
+	case reflect.Float64:
+		s := strconv.FormatFloat(v.Float(), 'f', -1, 64)
+		if !strings.Contains(s, ".") {
+			s += ".0"
+		}
+		return s
+	case reflect.Bool:
+		return strconv.FormatBool(v.Bool())
+	case reflect.Slice:
+		items := make([]string, v.Len())
+		for i := range items {
+			items[i] = scalarLiteral(v.Index(i))
+		}
+		return "[" + strings.Join(items, ", ") + "]"
+	}
+	return `""`
+}
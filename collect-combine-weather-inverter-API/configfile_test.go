@@ -0,0 +1,43 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestReferenceConfig(t *testing.T) {
+	// Uncommenting the example lists must still give a valid file.
+	examples := regexp.MustCompile(`(?m)^(\s*)# (\s*- .*|\s*\w+(?::| =).*|\[\[.*)$`)
+	for _, format := range []string{"yaml", "toml"} {
+		t.Run(format, func(t *testing.T) {
+			var out bytes.Buffer
+			if err := writeReferenceConfig(&out, format); err != nil {
+				t.Fatal(err)
+			}
+			for _, doc := range []string{"keyFile", "passwordFile", "HH:MM"} {
+				if !strings.Contains(out.String(), doc) {
+					t.Errorf("reference config does not document %s", doc)
+				}
+			}
+			for name, text := range map[string]string{"commented": out.String(), "uncommented": examples.ReplaceAllString(out.String(), "$1$2")} {
+				data, err := configJSON("config."+format, []byte(text))
+				if err != nil {
+					t.Fatalf("%s: %v\n%s", name, err, text)
+				}
+				decoder := json.NewDecoder(bytes.NewReader(data))
+				decoder.DisallowUnknownFields()
+				var config Config
+				if err := decoder.Decode(&config); err != nil {
+					t.Fatalf("%s: %v", name, err)
+				}
+				if name == "uncommented" && (len(config.Auth.APIKeys) != 1 || len(config.Auth.Users) != 1 || len(config.Tariff.TimeOfUse) != 1) {
+					t.Errorf("uncommented examples give %d keys, %d users and %d periods, want one each",
+						len(config.Auth.APIKeys), len(config.Auth.Users), len(config.Tariff.TimeOfUse))
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"embed"
+	"io/fs"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+//go:embed web
+var webFiles embed.FS
+
+// dashboardHandler serves the web UI in web/. It only holds static files;
+// the data comes from /stream and /v2/power-curve with the browser's
+// credentials.
+func dashboardHandler() http.Handler {
+	root, err := fs.Sub(webFiles, "web")
+	if err != nil {
+		panic(err)
+	}
+	return http.FileServer(http.FS(root))
+}
+
+type PowerCurveV2 struct {
+	StationID   string         `json:"station_id"`
+	StepSeconds int            `json:"step_seconds" unit:"s" doc:"Width of each interval."`
+	Points      []PowerPointV2 `json:"points"`
+}
+
+type PowerPointV2 struct {
+	Time         time.Time `json:"time" doc:"Start of the interval, UTC."`
+	OutputPowerW float64   `json:"output_power_w" unit:"W" doc:"Mean output of the station over the interval, summed over inverters."`
+}
+
+func (s *Store) powerCurve(stationID string, from time.Time, to time.Time, step time.Duration) ([]PowerPointV2, error) {
+	series, err := s.inverterSeries(stationID, "output_power", "", true, from, to, step)
+	if err != nil {
+		return nil, err
+	}
+	points := make([]PowerPointV2, 0, len(series))
+	for _, point := range series {
+		points = append(points, PowerPointV2{Time: point.Time, OutputPowerW: point.Value})
+	}
+	return points, nil
+}
+
+func powerCurveHandler(configs *ConfigSource, store *Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if store == nil {
+			writeJSON(w, http.StatusServiceUnavailable, ErrorV2{Error: "power curve needs database.dsn to be configured"})
+			return
+		}
+		query := r.URL.Query()
+		stationID := orDefault(query.Get("station"), configs.Get().ClientConfig.StationInfo.StationID)
+		from, err := parseTimeParam(query.Get("from"), time.UTC)
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, ErrorV2{Error: "from: " + err.Error()})
+			return
+		}
+		to := time.Now().UTC()
+		if value := query.Get("to"); value != "" {
+			if to, err = parseTimeParam(value, time.UTC); err != nil {
+				writeJSON(w, http.StatusBadRequest, ErrorV2{Error: "to: " + err.Error()})
+				return
+			}
+		}
+		step := 300
+		if value := query.Get("step"); value != "" {
+			if step, err = strconv.Atoi(value); err != nil || step < 60 {
+				writeJSON(w, http.StatusBadRequest, ErrorV2{Error: "step must be a number of seconds, at least 60"})
+				return
+			}
+		}
+		points, err := store.powerCurve(stationID, from, to, time.Duration(step)*time.Second)
+		if err != nil {
+			writeJSON(w, http.StatusInternalServerError, ErrorV2{Error: err.Error()})
+			return
+		}
+		writeJSON(w, http.StatusOK, PowerCurveV2{StationID: stationID, StepSeconds: step, Points: points})
+	}
+}
@@ -0,0 +1,346 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// inverterSample is a stored inverter reading with the weather observed
+// alongside it, as used by the output analyses.
+type inverterSample struct {
+	SN          string
+	Name        string
+	Time        time.Time
+	Status      int
+	Output      float64
+	Capacity    float64
+	Array       float64
+	ClearSky    float64
+	Temperature sql.NullFloat64
+	GHI         float64
+	Cloud       sql.NullInt64
+}
+
+// potential is the output the weather allowed for, in W: array size times
+// the measured irradiance, or the clear-sky output reduced for cloud cover
+// (Kasten and Czeplak) when the provider has no irradiance. Without a known
+// array, in kWp, the inverter's rating stands in for it.
+func (s inverterSample) potential() float64 {
+	size, clearSky := s.Capacity, s.ClearSky
+	if s.Array > 0 {
+		size = s.Array
+		if s.Capacity > 0 {
+			clearSky *= s.Array / s.Capacity
+		}
+	}
+	if s.GHI > 0 && size > 0 {
+		return size * s.GHI
+	}
+	if !s.Cloud.Valid {
+		return clearSky
+	}
+	return clearSky * (1 - 0.75*math.Pow(float64(s.Cloud.Int64)/100, 3.4))
+}
+
+// expected is the output the inverter should have produced at its usual
+// performance ratio, never more than its rating: output held at the rating
+// is clipping, not derating.
+func (s inverterSample) expected(ratio float64) float64 {
+	expected := ratio * s.potential()
+	if s.Capacity > 0 {
+		expected = math.Min(expected, s.Capacity*1000)
+	}
+	return expected
+}
+
+// sampleGap is the longest gap between readings still treated as one
+// stretch of output.
+const sampleGap = 20 * time.Minute
+
+// sampleDuration is how long samples[i] stands for: until the next reading, or
+// for the last one of a stretch, as long as the one before.
+func sampleDuration(samples []inverterSample, i int) time.Duration {
+	if i+1 < len(samples) && samples[i+1].SN == samples[i].SN {
+		if d := samples[i+1].Time.Sub(samples[i].Time); d <= sampleGap {
+			return d
+		}
+	}
+	if i > 0 && samples[i-1].SN == samples[i].SN {
+		if d := samples[i].Time.Sub(samples[i-1].Time); d <= sampleGap {
+			return d
+		}
+	}
+	return 0
+}
+
+// inverterSamples returns the readings of a station's inverters in
+// [from, to), ordered by inverter and read time. Station-level backfill rows
+// are left out.
+func (s *Store) inverterSamples(stationID string, from time.Time, to time.Time) ([]inverterSample, error) {
+	if err := s.ensureSchema(); err != nil {
+		return nil, err
+	}
+	rows, err := s.db.Query(`select r.inverter_sn, coalesce(r.inverter_name, ''), r.read_time, coalesce(r.status, 0),
+		coalesce(r.output_power, 0), coalesce(r.capacity, 0), coalesce(r.clear_sky_output, 0), r.temperature,
+		coalesce(w.ghi, 0), w.cloud_percent
+		from inverter_readings r left join weather_readings w on w.id = r.weather_id
+		where r.station_id = ? and r.inverter_sn <> '' and r.read_time >= ? and r.read_time < ?
+		order by r.inverter_sn, r.read_time`, stationID, from.UTC(), to.UTC())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var samples []inverterSample
+	for rows.Next() {
+		var s inverterSample
+		err := rows.Scan(&s.SN, &s.Name, &s.Time, &s.Status, &s.Output, &s.Capacity, &s.ClearSky, &s.Temperature, &s.GHI, &s.Cloud)
+		if err != nil {
+			return nil, err
+		}
+		samples = append(samples, s)
+	}
+	return samples, rows.Err()
+}
+
+type DeratingReportV2 struct {
+	StationID       string               `json:"station_id"`
+	From            time.Time            `json:"from"`
+	To              time.Time            `json:"to"`
+	MinTemperatureC float64              `json:"min_temperature_c" unit:"°C" doc:"Inverter temperature from which a shortfall counts as derating."`
+	Events          []DeratingEventV2    `json:"events"`
+	Inverters       []DeratingBaselineV2 `json:"inverters" doc:"Baseline of each inverter in the range."`
+}
+
+type DeratingBaselineV2 struct {
+	SerialNumber     string  `json:"serial_number"`
+	Name             string  `json:"name"`
+	PerformanceRatio float64 `json:"performance_ratio" unit:"1" doc:"Median output over potential output on cooler readings; the expected output is the potential times this ratio."`
+	MaxTemperatureC  float64 `json:"max_temperature_c" unit:"°C"`
+}
+
+type DeratingEventV2 struct {
+	SerialNumber      string    `json:"serial_number"`
+	Name              string    `json:"name"`
+	Start             time.Time `json:"start" doc:"First reading of the event, UTC."`
+	End               time.Time `json:"end" doc:"Last reading of the event, UTC."`
+	StartTemperatureC float64   `json:"start_temperature_c" unit:"°C" doc:"Lowest temperature in the hour leading up to the event."`
+	PeakTemperatureC  float64   `json:"peak_temperature_c" unit:"°C"`
+	MeanOutputW       float64   `json:"mean_output_w" unit:"W"`
+	MeanExpectedW     float64   `json:"mean_expected_w" unit:"W" doc:"What the weather allowed for at the inverter's usual performance ratio."`
+	CloudCoverPercent *float64  `json:"cloud_cover_percent" unit:"%" doc:"Mean over the event. Null without cloud observations."`
+	LostKWh           float64   `json:"lost_kwh" unit:"kWh" doc:"Estimated energy lost: expected less actual output over the event."`
+	Readings          int       `json:"readings"`
+}
+
+// deratingOptions tune detectDerating. A reading is derated when the
+// inverter is at least minTemperature, the weather allowed for a meaningful
+// output and the output falls short of the expected by more than shortfall;
+// consecutive derated readings form an event, kept when the temperature rose
+// by minRise going into it.
+type deratingOptions struct {
+	minTemperature float64
+	shortfall      float64
+	minRise        float64
+}
+
+// minPotentialShare ignores readings where the weather allowed for less
+// than this share of capacity, when shortfalls are mostly noise.
+const minPotentialShare = 0.2
+
+func detectDerating(samples []inverterSample, options deratingOptions) ([]DeratingEventV2, []DeratingBaselineV2) {
+	events := []DeratingEventV2{}
+	baselines := []DeratingBaselineV2{}
+	for start := 0; start < len(samples); {
+		end := start
+		for end < len(samples) && samples[end].SN == samples[start].SN {
+			end++
+		}
+		inverter := samples[start:end]
+		baseline := DeratingBaselineV2{
+			SerialNumber:     inverter[0].SN,
+			Name:             inverter[len(inverter)-1].Name,
+			PerformanceRatio: baselineRatio(inverter, options.minTemperature),
+		}
+		for _, s := range inverter {
+			if s.Temperature.Valid && s.Temperature.Float64 > baseline.MaxTemperatureC {
+				baseline.MaxTemperatureC = s.Temperature.Float64
+			}
+		}
+		baselines = append(baselines, baseline)
+		events = append(events, deratingEvents(inverter, baseline.PerformanceRatio, options)...)
+		start = end
+	}
+	sort.Slice(events, func(i, j int) bool { return events[i].Start.Before(events[j].Start) })
+	return events, baselines
+}
+
+// baselineRatio is the inverter's median ratio of output to potential on
+// readings below minTemperature, so systematic losses such as orientation,
+// shading or soiling are not mistaken for derating.
+func baselineRatio(samples []inverterSample, minTemperature float64) float64 {
+	return math.Min(medianRatio(samples, minTemperature), 1)
+}
+
+// medianRatio is baselineRatio without the cap at 1.
+func medianRatio(samples []inverterSample, minTemperature float64) float64 {
+	var ratios []float64
+	for _, s := range samples {
+		potential := s.potential()
+		if s.Output <= 0 || potential < minPotentialShare*s.Capacity*1000 {
+			continue
+		}
+		// Readings at the rating say nothing about the ratio.
+		if s.Capacity > 0 && s.Output >= 0.95*s.Capacity*1000 {
+			continue
+		}
+		if s.Temperature.Valid && s.Temperature.Float64 >= minTemperature {
+			continue
+		}
+		ratios = append(ratios, s.Output/potential)
+	}
+	if len(ratios) < 5 {
+		return 0.8
+	}
+	sort.Float64s(ratios)
+	return ratios[len(ratios)/2]
+}
+
+func deratingEvents(samples []inverterSample, ratio float64, options deratingOptions) []DeratingEventV2 {
+	derated := func(s inverterSample) bool {
+		potential := s.potential()
+		return s.Temperature.Valid && s.Temperature.Float64 >= options.minTemperature && s.Status != 2 && s.Output > 0 &&
+			potential >= minPotentialShare*s.Capacity*1000 && s.Output < s.expected(ratio)*(1-options.shortfall)
+	}
+	var events []DeratingEventV2
+	for i := 0; i < len(samples); i++ {
+		if !derated(samples[i]) {
+			continue
+		}
+		j := i
+		for j+1 < len(samples) && derated(samples[j+1]) && samples[j+1].Time.Sub(samples[j].Time) <= sampleGap {
+			j++
+		}
+		event := DeratingEventV2{
+			SerialNumber:      samples[i].SN,
+			Name:              samples[i].Name,
+			Start:             samples[i].Time,
+			End:               samples[j].Time,
+			StartTemperatureC: samples[i].Temperature.Float64,
+			Readings:          j - i + 1,
+		}
+		for k := i - 1; k >= 0 && samples[i].Time.Sub(samples[k].Time) <= time.Hour; k-- {
+			if samples[k].Temperature.Valid && samples[k].Temperature.Float64 < event.StartTemperatureC {
+				event.StartTemperatureC = samples[k].Temperature.Float64
+			}
+		}
+		var cloud, clouds float64
+		for k := i; k <= j; k++ {
+			s := samples[k]
+			expected := s.expected(ratio)
+			event.PeakTemperatureC = math.Max(event.PeakTemperatureC, s.Temperature.Float64)
+			event.MeanOutputW += s.Output / float64(event.Readings)
+			event.MeanExpectedW += expected / float64(event.Readings)
+			event.LostKWh += (expected - s.Output) * sampleDuration(samples, k).Hours() / 1000
+			if s.Cloud.Valid {
+				cloud += float64(s.Cloud.Int64)
+				clouds++
+			}
+		}
+		if clouds > 0 {
+			mean := cloud / clouds
+			event.CloudCoverPercent = &mean
+		}
+		if event.PeakTemperatureC-event.StartTemperatureC >= options.minRise {
+			events = append(events, event)
+		}
+		i = j
+	}
+	return events
+}
+
+// maxAnalysisDays bounds the range of the analysis reports.
+const maxAnalysisDays = 366
+
+// analysisRange reads from and to for the analysis reports and exports: RFC
+// 3339 or YYYY-MM-DD (UTC), to defaulting to now and from to a week before it.
+func analysisRange(w http.ResponseWriter, r *http.Request, maxDays int) (time.Time, time.Time, bool) {
+	query := r.URL.Query()
+	to := time.Now().UTC()
+	if value := query.Get("to"); value != "" {
+		var err error
+		if to, err = parseTimeParam(value, time.UTC); err != nil {
+			writeJSON(w, http.StatusBadRequest, ErrorV2{Error: "to: " + err.Error()})
+			return time.Time{}, time.Time{}, false
+		}
+	}
+	from := to.AddDate(0, 0, -7)
+	if value := query.Get("from"); value != "" {
+		var err error
+		if from, err = parseTimeParam(value, time.UTC); err != nil {
+			writeJSON(w, http.StatusBadRequest, ErrorV2{Error: "from: " + err.Error()})
+			return time.Time{}, time.Time{}, false
+		}
+	}
+	if !from.Before(to) || to.Sub(from) > time.Duration(maxDays)*24*time.Hour {
+		writeJSON(w, http.StatusBadRequest, ErrorV2{Error: fmt.Sprintf("from must be before to and at most %d days earlier", maxDays)})
+		return time.Time{}, time.Time{}, false
+	}
+	return from, to, true
+}
+
+// floatParam reads an optional number query parameter within [low, high].
+func floatParam(w http.ResponseWriter, r *http.Request, name string, fallback float64, low float64, high float64) (float64, bool) {
+	raw := r.URL.Query().Get(name)
+	if raw == "" {
+		return fallback, true
+	}
+	value, err := strconv.ParseFloat(raw, 64)
+	if err != nil || value < low || value > high {
+		writeJSON(w, http.StatusBadRequest, ErrorV2{Error: name + " must be a number from " +
+			strconv.FormatFloat(low, 'f', -1, 64) + " to " + strconv.FormatFloat(high, 'f', -1, 64)})
+		return 0, false
+	}
+	return value, true
+}
+
+func deratingHandler(configs *ConfigSource, store *Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if store == nil {
+			writeJSON(w, http.StatusServiceUnavailable, ErrorV2{Error: "derating events need database.dsn to be configured"})
+			return
+		}
+		from, to, ok := analysisRange(w, r, maxAnalysisDays)
+		if !ok {
+			return
+		}
+		options := deratingOptions{minRise: 1}
+		if options.minTemperature, ok = floatParam(w, r, "min_temperature", 50, 0, 120); !ok {
+			return
+		}
+		shortfall, ok := floatParam(w, r, "shortfall_percent", 15, 1, 90)
+		if !ok {
+			return
+		}
+		options.shortfall = shortfall / 100
+		stationID := orDefault(r.URL.Query().Get("station"), configs.Get().ClientConfig.StationInfo.StationID)
+		samples, err := store.inverterSamples(stationID, from, to)
+		if err != nil {
+			writeJSON(w, http.StatusInternalServerError, ErrorV2{Error: err.Error()})
+			return
+		}
+		events, inverters := detectDerating(samples, options)
+		writeJSON(w, http.StatusOK, DeratingReportV2{
+			StationID:       stationID,
+			From:            from,
+			To:              to,
+			MinTemperatureC: options.minTemperature,
+			Events:          events,
+			Inverters:       inverters,
+		})
+	}
+}
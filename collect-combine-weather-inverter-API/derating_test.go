@@ -0,0 +1,170 @@
+package main
+
+import (
+	"database/sql"
+	"math"
+	"testing"
+	"time"
+)
+
+// deratingSample is a reading of a 5 kW inverter; at 800 W/m² the weather
+// allows for 4000 W.
+func deratingSample(minute int, temperature float64, output float64) inverterSample {
+	return inverterSample{
+		SN:          "inv1",
+		Time:        time.Date(2026, 1, 10, 2, 0, 0, 0, time.UTC).Add(time.Duration(minute) * time.Minute),
+		Status:      1,
+		Output:      output,
+		Capacity:    5,
+		Temperature: sql.NullFloat64{Float64: temperature, Valid: true},
+		GHI:         800,
+	}
+}
+
+func TestMedianRatio(t *testing.T) {
+	cool := func(outputs ...float64) []inverterSample {
+		var samples []inverterSample
+		for i, output := range outputs {
+			samples = append(samples, deratingSample(5*i, 30, output))
+		}
+		return samples
+	}
+	atRating := deratingSample(60, 30, 4900)
+	atRating.GHI = 1200
+	hot := deratingSample(60, 55, 2000)
+	dim := deratingSample(60, 30, 400)
+	dim.GHI = 100
+	bright := cool(3000, 3000, 3000, 3000, 3000)
+	for i := range bright {
+		bright[i].GHI = 500
+	}
+	tests := []struct {
+		name    string
+		samples []inverterSample
+		want    float64
+	}{
+		{"median of the cool readings", cool(3600, 3400, 3500, 3300, 3700), 0.875},
+		{"fewer than 5 readings", cool(3600, 3400, 3500, 3300), 0.8},
+		{"reading at the rating skipped", append(cool(3600, 3400, 3500, 3300), atRating), 0.8},
+		{"hot reading skipped", append(cool(3600, 3400, 3500, 3300), hot), 0.8},
+		{"low potential skipped", append(cool(3600, 3400, 3500, 3300), dim), 0.8},
+		{"not capped", bright, 1.2},
+	}
+	for _, test := range tests {
+		if got := medianRatio(test.samples, 50); math.Abs(got-test.want) > 1e-9 {
+			t.Errorf("%s: medianRatio = %v, want %v", test.name, got, test.want)
+		}
+	}
+	if got := baselineRatio(bright, 50); got != 1 {
+		t.Errorf("baselineRatio = %v, want 1", got)
+	}
+}
+
+func TestDeratingEvents(t *testing.T) {
+	// At a ratio of 0.9 the expected output is 3600 W; below 3240 W is derated.
+	options := deratingOptions{minTemperature: 60, shortfall: 0.1, minRise: 5}
+	faulted := func(s inverterSample) inverterSample {
+		s.Status = 2
+		return s
+	}
+	atRating := deratingSample(10, 64, 5000)
+	atRating.GHI = 1200
+	// 1600 W short for two 5 minute readings.
+	const lost = 2 * 1600.0 / 12 / 1000
+	tests := []struct {
+		name    string
+		samples []inverterSample
+		want    []DeratingEventV2
+	}{
+		{
+			name: "temperature rose into the event",
+			samples: []inverterSample{
+				deratingSample(0, 50, 3600), deratingSample(5, 55, 3600), deratingSample(10, 62, 2000),
+				deratingSample(15, 64, 2000), deratingSample(20, 63, 3600),
+			},
+			want: []DeratingEventV2{{StartTemperatureC: 50, PeakTemperatureC: 64, Readings: 2, LostKWh: lost}},
+		},
+		{
+			name: "temperature held steady",
+			samples: []inverterSample{
+				deratingSample(0, 62, 3600), deratingSample(5, 62, 2000), deratingSample(10, 63, 2000),
+			},
+		},
+		{
+			name: "faulted readings skipped",
+			samples: []inverterSample{
+				deratingSample(0, 50, 3600), faulted(deratingSample(5, 62, 2000)), faulted(deratingSample(10, 64, 2000)),
+			},
+		},
+		{
+			name: "reading at the rating is not derated",
+			samples: []inverterSample{
+				deratingSample(0, 50, 3600), deratingSample(5, 55, 3600), atRating,
+			},
+		},
+		{
+			name: "last reading before a gap counts as long as the one before",
+			samples: []inverterSample{
+				deratingSample(0, 50, 3600), deratingSample(10, 62, 2000), deratingSample(15, 64, 2000),
+				deratingSample(50, 50, 3600),
+			},
+			want: []DeratingEventV2{{StartTemperatureC: 50, PeakTemperatureC: 64, Readings: 2, LostKWh: lost}},
+		},
+		{
+			name: "isolated reading loses nothing measurable",
+			samples: []inverterSample{
+				deratingSample(0, 50, 3600), deratingSample(30, 65, 2000), deratingSample(60, 50, 3600),
+			},
+			want: []DeratingEventV2{{StartTemperatureC: 50, PeakTemperatureC: 65, Readings: 1}},
+		},
+		{
+			name: "gap splits events",
+			samples: []inverterSample{
+				deratingSample(0, 50, 3600), deratingSample(5, 62, 2000), deratingSample(10, 64, 2000),
+				deratingSample(40, 66, 2000), deratingSample(45, 67, 2000),
+			},
+			want: []DeratingEventV2{
+				{StartTemperatureC: 50, PeakTemperatureC: 64, Readings: 2, LostKWh: lost},
+				{StartTemperatureC: 50, PeakTemperatureC: 67, Readings: 2, LostKWh: lost},
+			},
+		},
+	}
+	for _, test := range tests {
+		got := deratingEvents(test.samples, 0.9, options)
+		if len(got) != len(test.want) {
+			t.Errorf("%s: got %d events, want %d: %+v", test.name, len(got), len(test.want), got)
+			continue
+		}
+		for i, want := range test.want {
+			event := got[i]
+			if event.StartTemperatureC != want.StartTemperatureC || event.PeakTemperatureC != want.PeakTemperatureC ||
+				event.Readings != want.Readings || math.Abs(event.LostKWh-want.LostKWh) > 1e-9 {
+				t.Errorf("%s: event %d = %+v, want %+v", test.name, i, event, want)
+			}
+		}
+	}
+}
+
+func TestDetectDerating(t *testing.T) {
+	var samples []inverterSample
+	for i, output := range []float64{3600, 3400, 3500, 3300, 3700} {
+		samples = append(samples, deratingSample(5*i, 30, output))
+	}
+	samples = append(samples, deratingSample(60, 40, 3500), deratingSample(65, 62, 2000), deratingSample(70, 63, 2000))
+	other := []inverterSample{deratingSample(30, 50, 3600), deratingSample(35, 62, 1000), deratingSample(40, 64, 1000)}
+	for i := range other {
+		other[i].SN = "inv2"
+	}
+	events, baselines := detectDerating(append(samples, other...), deratingOptions{minTemperature: 60, shortfall: 0.1, minRise: 5})
+
+	if len(baselines) != 2 || baselines[0].SerialNumber != "inv1" || math.Abs(baselines[0].PerformanceRatio-0.875) > 1e-9 ||
+		baselines[0].MaxTemperatureC != 63 || baselines[1].SerialNumber != "inv2" || baselines[1].PerformanceRatio != 0.8 ||
+		baselines[1].MaxTemperatureC != 64 {
+		t.Errorf("baselines = %+v", baselines)
+	}
+	// Events of all inverters are ordered by start.
+	if len(events) != 2 || events[0].SerialNumber != "inv2" || events[1].SerialNumber != "inv1" ||
+		!events[1].Start.Equal(samples[6].Time) || !events[1].End.Equal(samples[7].Time) {
+		t.Errorf("events = %+v", events)
+	}
+}
@@ -0,0 +1,301 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/csv"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+)
+
+// ExportRow is one stored inverter reading joined with the weather observed
+// alongside it. Times are UTC milliseconds so the row maps directly onto a
+// Parquet TIMESTAMP_MILLIS column; zero means unknown.
+type ExportRow struct {
+	StationID          string   `parquet:"name=station_id, type=BYTE_ARRAY, convertedtype=UTF8"`
+	InverterSN         string   `parquet:"name=inverter_sn, type=BYTE_ARRAY, convertedtype=UTF8"`
+	InverterName       string   `parquet:"name=inverter_name, type=BYTE_ARRAY, convertedtype=UTF8"`
+	Source             string   `parquet:"name=source, type=BYTE_ARRAY, convertedtype=UTF8"`
+	ReadTime           int64    `parquet:"name=read_time, type=INT64, convertedtype=TIMESTAMP_MILLIS"`
+	BootTime           int64    `parquet:"name=boot_time, type=INT64, convertedtype=TIMESTAMP_MILLIS"`
+	Capacity           float64  `parquet:"name=capacity, type=DOUBLE"`
+	OutputPower        float64  `parquet:"name=output_power, type=DOUBLE"`
+	EnergyDay          float64  `parquet:"name=energy_day, type=DOUBLE"`
+	EnergyMonth        float64  `parquet:"name=energy_month, type=DOUBLE"`
+	EnergyTotal        float64  `parquet:"name=energy_total, type=DOUBLE"`
+	ClearSkyOutput     float64  `parquet:"name=clear_sky_output, type=DOUBLE"`
+	PerformanceRatio   float64  `parquet:"name=performance_ratio, type=DOUBLE"`
+	SpecificYield      float64  `parquet:"name=specific_yield, type=DOUBLE"`
+	Status             int32    `parquet:"name=status, type=INT32"`
+	WorkHours          float64  `parquet:"name=work_hours, type=DOUBLE"`
+	Vpv1               float64  `parquet:"name=vpv1, type=DOUBLE"`
+	Vpv2               float64  `parquet:"name=vpv2, type=DOUBLE"`
+	Vpv3               float64  `parquet:"name=vpv3, type=DOUBLE"`
+	Vpv4               float64  `parquet:"name=vpv4, type=DOUBLE"`
+	Ipv1               float64  `parquet:"name=ipv1, type=DOUBLE"`
+	Ipv2               float64  `parquet:"name=ipv2, type=DOUBLE"`
+	Ipv3               float64  `parquet:"name=ipv3, type=DOUBLE"`
+	Ipv4               float64  `parquet:"name=ipv4, type=DOUBLE"`
+	Vac1               float64  `parquet:"name=vac1, type=DOUBLE"`
+	Vac2               float64  `parquet:"name=vac2, type=DOUBLE"`
+	Vac3               float64  `parquet:"name=vac3, type=DOUBLE"`
+	Iac1               float64  `parquet:"name=iac1, type=DOUBLE"`
+	Iac2               float64  `parquet:"name=iac2, type=DOUBLE"`
+	Iac3               float64  `parquet:"name=iac3, type=DOUBLE"`
+	Fac1               float64  `parquet:"name=fac1, type=DOUBLE"`
+	Fac2               float64  `parquet:"name=fac2, type=DOUBLE"`
+	Fac3               float64  `parquet:"name=fac3, type=DOUBLE"`
+	InverterTemp       *float64 `parquet:"name=inverter_temperature, type=DOUBLE, repetitiontype=OPTIONAL"`
+	CurrentTemperature float64  `parquet:"name=current_temp, type=DOUBLE"`
+	CloudPercent       *int32   `parquet:"name=cloud_percent, type=INT32, repetitiontype=OPTIONAL"`
+	WeatherType        string   `parquet:"name=weather, type=BYTE_ARRAY, convertedtype=UTF8"`
+	WeatherDescription string   `parquet:"name=weather_description, type=BYTE_ARRAY, convertedtype=UTF8"`
+	Sunrise            int64    `parquet:"name=sunrise, type=INT64, convertedtype=TIMESTAMP_MILLIS"`
+	Sunset             int64    `parquet:"name=sunset, type=INT64, convertedtype=TIMESTAMP_MILLIS"`
+	GHI                *float64 `parquet:"name=ghi, type=DOUBLE, repetitiontype=OPTIONAL"`
+	DNI                float64  `parquet:"name=dni, type=DOUBLE"`
+	DHI                float64  `parquet:"name=dhi, type=DOUBLE"`
+}
+
+var exportColumns = []string{
+	"station_id", "inverter_sn", "inverter_name", "source", "read_time", "boot_time", "capacity", "output_power",
+	"energy_day", "energy_month", "energy_total", "clear_sky_output", "performance_ratio", "specific_yield", "status",
+	"work_hours", "vpv1", "vpv2", "vpv3", "vpv4", "ipv1", "ipv2", "ipv3", "ipv4", "vac1", "vac2", "vac3",
+	"iac1", "iac2", "iac3", "fac1", "fac2", "fac3", "inverter_temperature", "current_temp", "cloud_percent", "weather",
+	"weather_description", "sunrise", "sunset", "ghi", "dni", "dhi",
+}
+
+func (row ExportRow) csvRecord() []string {
+	f := func(v float64) string { return strconv.FormatFloat(v, 'f', -1, 64) }
+	// Missing readings are left empty rather than written as 0.
+	optional := func(v *float64) string {
+		if v == nil {
+			return ""
+		}
+		return f(*v)
+	}
+	cloud := ""
+	if row.CloudPercent != nil {
+		cloud = strconv.Itoa(int(*row.CloudPercent))
+	}
+	return []string{
+		row.StationID, row.InverterSN, row.InverterName, row.Source, formatMillis(row.ReadTime), formatMillis(row.BootTime),
+		f(row.Capacity), f(row.OutputPower), f(row.EnergyDay), f(row.EnergyMonth), f(row.EnergyTotal),
+		f(row.ClearSkyOutput), f(row.PerformanceRatio), f(row.SpecificYield), strconv.Itoa(int(row.Status)),
+		f(row.WorkHours), f(row.Vpv1), f(row.Vpv2), f(row.Vpv3), f(row.Vpv4), f(row.Ipv1), f(row.Ipv2), f(row.Ipv3),
+		f(row.Ipv4), f(row.Vac1), f(row.Vac2), f(row.Vac3), f(row.Iac1), f(row.Iac2), f(row.Iac3), f(row.Fac1),
+		f(row.Fac2), f(row.Fac3), optional(row.InverterTemp), f(row.CurrentTemperature), cloud, row.WeatherType,
+		row.WeatherDescription, formatMillis(row.Sunrise), formatMillis(row.Sunset), optional(row.GHI), f(row.DNI), f(row.DHI),
+	}
+}
+
+func formatMillis(ms int64) string {
+	if ms == 0 {
+		return ""
+	}
+	return time.UnixMilli(ms).UTC().Format(time.RFC3339)
+}
+
+func toMillis(t sql.NullTime) int64 {
+	if !t.Valid {
+		return 0
+	}
+	return t.Time.UnixMilli()
+}
+
+type exportWriter interface {
+	write(row ExportRow) error
+	close() error
+}
+
+type csvExportWriter struct {
+	w    *csv.Writer
+	rows int
+}
+
+func newCSVExportWriter(w io.Writer) (exportWriter, error) {
+	cw := csv.NewWriter(w)
+	if err := cw.Write(exportColumns); err != nil {
+		return nil, err
+	}
+	return &csvExportWriter{w: cw}, nil
+}
+
+func (c *csvExportWriter) write(row ExportRow) error {
+	if err := c.w.Write(row.csvRecord()); err != nil {
+		return err
+	}
+	c.rows++
+	if c.rows%1000 == 0 {
+		c.w.Flush()
+	}
+	return c.w.Error()
+}
+
+func (c *csvExportWriter) close() error {
+	c.w.Flush()
+	return c.w.Error()
+}
+
+func newExportWriter(format string, w io.Writer) (exportWriter, error) {
+	switch format {
+	case "", "csv":
+		return newCSVExportWriter(w)
+	case "parquet":
+		return newParquetExportWriter(w)
+	}
+	return nil, fmt.Errorf("unknown export format %q, expected csv or parquet", format)
+}
+
+// eachReading streams readings for a station in [from, to) ordered by read
+// time without holding the result set in memory.
+func (s *Store) eachReading(stationID string, from time.Time, to time.Time, fn func(ExportRow) error) error {
+	if err := s.ensureSchema(); err != nil {
+		return err
+	}
+	rows, err := s.db.Query(`select r.station_id, r.inverter_sn, coalesce(r.inverter_name, ''), r.source, r.read_time, r.boot_time,
+		coalesce(r.capacity, 0), coalesce(r.output_power, 0), coalesce(r.energy_day, 0), coalesce(r.energy_month, 0),
+		coalesce(r.energy_total, 0), coalesce(r.clear_sky_output, 0), coalesce(r.performance_ratio, 0),
+		coalesce(r.specific_yield, 0), coalesce(r.status, 0), coalesce(r.work_hours, 0),
+		coalesce(r.vpv1, 0), coalesce(r.vpv2, 0), coalesce(r.vpv3, 0), coalesce(r.vpv4, 0),
+		coalesce(r.ipv1, 0), coalesce(r.ipv2, 0), coalesce(r.ipv3, 0), coalesce(r.ipv4, 0),
+		coalesce(r.vac1, 0), coalesce(r.vac2, 0), coalesce(r.vac3, 0), coalesce(r.iac1, 0), coalesce(r.iac2, 0), coalesce(r.iac3, 0),
+		coalesce(r.fac1, 0), coalesce(r.fac2, 0), coalesce(r.fac3, 0), r.temperature,
+		coalesce(w.temperature, 0), w.cloud_percent, coalesce(w.weather, ''), coalesce(w.weather_description, ''),
+		w.sunrise, w.sunset, w.ghi, coalesce(w.dni, 0), coalesce(w.dhi, 0)
+		from inverter_readings r left join weather_readings w on w.id = r.weather_id
+		where r.station_id = ? and r.read_time >= ? and r.read_time < ?
+		order by r.read_time, r.inverter_sn`, stationID, from.UTC(), to.UTC())
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var row ExportRow
+		var readTime time.Time
+		var bootTime, sunrise, sunset sql.NullTime
+		err := rows.Scan(&row.StationID, &row.InverterSN, &row.InverterName, &row.Source, &readTime, &bootTime,
+			&row.Capacity, &row.OutputPower, &row.EnergyDay, &row.EnergyMonth, &row.EnergyTotal, &row.ClearSkyOutput,
+			&row.PerformanceRatio, &row.SpecificYield, &row.Status, &row.WorkHours,
+			&row.Vpv1, &row.Vpv2, &row.Vpv3, &row.Vpv4, &row.Ipv1, &row.Ipv2, &row.Ipv3, &row.Ipv4,
+			&row.Vac1, &row.Vac2, &row.Vac3, &row.Iac1, &row.Iac2, &row.Iac3, &row.Fac1, &row.Fac2, &row.Fac3,
+			&row.InverterTemp, &row.CurrentTemperature, &row.CloudPercent, &row.WeatherType, &row.WeatherDescription,
+			&sunrise, &sunset, &row.GHI, &row.DNI, &row.DHI)
+		if err != nil {
+			return err
+		}
+		row.ReadTime = readTime.UnixMilli()
+		row.BootTime = toMillis(bootTime)
+		row.Sunrise = toMillis(sunrise)
+		row.Sunset = toMillis(sunset)
+		if err := fn(row); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+func exportReadings(store *Store, w io.Writer, format string, stationID string, from time.Time, to time.Time) error {
+	ew, err := newExportWriter(format, w)
+	if err != nil {
+		return err
+	}
+	if err := store.eachReading(stationID, from, to, ew.write); err != nil {
+		return err
+	}
+	return ew.close()
+}
+
+// parseTimeParam accepts RFC 3339 or a plain YYYY-MM-DD date, which is taken
+// as midnight in loc.
+func parseTimeParam(value string, loc *time.Location) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	return time.ParseInLocation(dateLayout, value, loc)
+}
+
+// maxExportDays bounds the range of a single export over HTTP; the export
+// command has no limit.
+const maxExportDays = 5 * 366
+
+func exportHandler(configs *ConfigSource, store *Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if store == nil {
+			writeJSON(w, http.StatusServiceUnavailable, ErrorV2{Error: "export needs database.dsn to be configured"})
+			return
+		}
+		query := r.URL.Query()
+		stationID := query.Get("station")
+		if stationID == "" {
+			stationID = configs.Get().ClientConfig.StationInfo.StationID
+		}
+		from, to, ok := analysisRange(w, r, maxExportDays)
+		if !ok {
+			return
+		}
+		format := query.Get("format")
+		if format == "" {
+			format = "csv"
+		}
+		if format != "csv" && format != "parquet" {
+			writeJSON(w, http.StatusBadRequest, ErrorV2{Error: "format must be csv or parquet"})
+			return
+		}
+		contentType := "text/csv"
+		if format == "parquet" {
+			contentType = "application/vnd.apache.parquet"
+		}
+		w.Header().Set("Content-Type", contentType)
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", "readings-"+stationID+"."+format))
+		if err := exportReadings(store, w, format, stationID, from, to); err != nil {
+			// Headers are gone once streaming has started; all that is left is to log and cut the body short.
+			logger.Error("export failed", "err", err)
+		}
+	}
+}
+
+func runExport(args []string) {
+	config := importConfig()
+	flags := flag.NewFlagSet("export", flag.ExitOnError)
+	from := flags.String("from", "", "start of the range, RFC 3339 or YYYY-MM-DD (UTC)")
+	to := flags.String("to", time.Now().UTC().Format(time.RFC3339), "end of the range (exclusive)")
+	stationID := flags.String("station", config.ClientConfig.StationInfo.StationID, "station ID")
+	format := flags.String("format", "csv", "csv or parquet")
+	output := flags.String("output", "-", "file to write, - for stdout")
+	flags.Parse(args)
+
+	if config.Database.DSN == "" {
+		log.Fatal("export: database.dsn is not configured")
+	}
+	fromTime, err := parseTimeParam(*from, time.UTC)
+	if err != nil {
+		log.Fatalf("export: --from: %v", err)
+	}
+	toTime, err := parseTimeParam(*to, time.UTC)
+	if err != nil {
+		log.Fatalf("export: --to: %v", err)
+	}
+	store, err := openStore(config.Database.DSN)
+	if err != nil {
+		log.Fatalf("export: %v", err)
+	}
+	defer store.Close()
+
+	out := os.Stdout
+	if *output != "-" {
+		out, err = os.Create(*output)
+		if err != nil {
+			log.Fatalf("export: %v", err)
+		}
+		defer out.Close()
+	}
+	if err := exportReadings(store, out, *format, *stationID, fromTime, toTime); err != nil {
+		log.Fatalf("export: %v", err)
+	}
+}
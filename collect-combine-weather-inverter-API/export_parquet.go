@@ -0,0 +1,34 @@
+package main
+
+import (
+	"io"
+
+	"github.com/xitongsys/parquet-go/parquet"
+	"github.com/xitongsys/parquet-go/writer"
+)
+
+// parquetRowGroupSize bounds how much of an export is buffered before a row
+// group is flushed to the output.
+const parquetRowGroupSize = 8 * 1024 * 1024
+
+type parquetExportWriter struct {
+	pw *writer.ParquetWriter
+}
+
+func newParquetExportWriter(w io.Writer) (exportWriter, error) {
+	pw, err := writer.NewParquetWriterFromWriter(w, new(ExportRow), 1)
+	if err != nil {
+		return nil, err
+	}
+	pw.RowGroupSize = parquetRowGroupSize
+	pw.CompressionType = parquet.CompressionCodec_SNAPPY
+	return &parquetExportWriter{pw: pw}, nil
+}
+
+func (p *parquetExportWriter) write(row ExportRow) error {
+	return p.pw.Write(row)
+}
+
+func (p *parquetExportWriter) close() error {
+	return p.pw.WriteStop()
+}
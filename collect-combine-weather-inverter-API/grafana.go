@@ -0,0 +1,355 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// The Grafana endpoints follow the SimpleJSON datasource contract, which the
+// JSON and Infinity datasources can also read, so Grafana needs an API key
+// rather than access to MySQL.
+
+type GrafanaRange struct {
+	From time.Time `json:"from"`
+	To   time.Time `json:"to"`
+}
+
+// GrafanaOptions are read from a target's data (SimpleJSON) or payload
+// (the newer JSON datasource).
+type GrafanaOptions struct {
+	Station string `json:"station" doc:"Station ID. Default: the configured station."`
+}
+
+type GrafanaSearchRequest struct {
+	Target string `json:"target" doc:"Only metrics containing this text are listed."`
+}
+
+type GrafanaTarget struct {
+	Target  string          `json:"target" doc:"A metric from /search: a station metric, or an inverter metric followed by :<serial number>."`
+	RefID   string          `json:"refId,omitempty"`
+	Type    string          `json:"type,omitempty" doc:"timeserie (default) or table."`
+	Data    *GrafanaOptions `json:"data,omitempty"`
+	Payload *GrafanaOptions `json:"payload,omitempty"`
+}
+
+type GrafanaQueryRequest struct {
+	Range      GrafanaRange    `json:"range"`
+	IntervalMs int64           `json:"intervalMs" unit:"ms" doc:"Width of each point; at least a minute is used."`
+	Targets    []GrafanaTarget `json:"targets"`
+}
+
+type GrafanaColumn struct {
+	Text string `json:"text"`
+	Type string `json:"type"`
+}
+
+// GrafanaQueryResult is a time series, or with Type "table" a table.
+type GrafanaQueryResult struct {
+	Target     string          `json:"target,omitempty"`
+	Datapoints [][2]float64    `json:"datapoints,omitempty" doc:"[value, Unix time in ms] pairs."`
+	Type       string          `json:"type,omitempty"`
+	Columns    []GrafanaColumn `json:"columns,omitempty"`
+	Rows       [][]interface{} `json:"rows,omitempty"`
+}
+
+type GrafanaAnnotationQuery struct {
+	Name  string `json:"name"`
+	Query string `json:"query" doc:"faults, weather, or empty for both."`
+}
+
+type GrafanaAnnotationRequest struct {
+	Range      GrafanaRange           `json:"range"`
+	Annotation GrafanaAnnotationQuery `json:"annotation"`
+}
+
+type GrafanaAnnotation struct {
+	Annotation GrafanaAnnotationQuery `json:"annotation"`
+	Time       int64                  `json:"time" unit:"ms" doc:"Unix time."`
+	TimeEnd    int64                  `json:"timeEnd,omitempty" unit:"ms" doc:"End of a fault, when it has ended within the range."`
+	Title      string                 `json:"title"`
+	Text       string                 `json:"text"`
+	Tags       []string               `json:"tags"`
+}
+
+// grafanaMetric maps a metric name, the same as the v2 key, to the column it
+// is read from.
+type grafanaMetric struct {
+	Name     string
+	Column   string
+	Inverter bool
+	// Sum adds inverters up; otherwise they are averaged.
+	Sum bool
+}
+
+var grafanaMetrics = []grafanaMetric{
+	{Name: "output_power_w", Column: "output_power", Inverter: true, Sum: true},
+	{Name: "energy_today_kwh", Column: "energy_day", Inverter: true, Sum: true},
+	{Name: "clear_sky_output_w", Column: "clear_sky_output", Inverter: true, Sum: true},
+	{Name: "performance_ratio", Column: "performance_ratio", Inverter: true},
+	{Name: "specific_yield_kwh_per_kwp", Column: "specific_yield", Inverter: true},
+	{Name: "inverter_temperature_c", Column: "temperature", Inverter: true},
+	{Name: "temperature_c", Column: "temperature"},
+	{Name: "humidity_percent", Column: "humidity"},
+	{Name: "cloud_cover_percent", Column: "cloud_percent"},
+	{Name: "wind_speed_m_s", Column: "wind_speed"},
+	{Name: "ghi_w_m2", Column: "ghi"},
+}
+
+func findGrafanaMetric(name string) (grafanaMetric, bool) {
+	for _, metric := range grafanaMetrics {
+		if metric.Name == name {
+			return metric, true
+		}
+	}
+	return grafanaMetric{}, false
+}
+
+// recentInverters lists the inverters seen at a station in the last year.
+func (s *Store) recentInverters(stationID string) ([]string, error) {
+	if err := s.ensureSchema(); err != nil {
+		return nil, err
+	}
+	rows, err := s.db.Query(`select distinct inverter_sn from inverter_readings
+		where station_id = ? and inverter_sn <> '' and read_time >= ? order by inverter_sn`,
+		stationID, time.Now().UTC().AddDate(-1, 0, 0))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var serials []string
+	for rows.Next() {
+		var sn string
+		if err := rows.Scan(&sn); err != nil {
+			return nil, err
+		}
+		serials = append(serials, sn)
+	}
+	return serials, rows.Err()
+}
+
+// faultAnnotations reports each stretch an inverter spent in the SEMS fault
+// status.
+func (s *Store) faultAnnotations(stationID string, from time.Time, to time.Time) ([]GrafanaAnnotation, error) {
+	if err := s.ensureSchema(); err != nil {
+		return nil, err
+	}
+	rows, err := s.db.Query(`select inverter_sn, coalesce(inverter_name, ''), read_time, status from inverter_readings
+		where station_id = ? and read_time >= ? and read_time < ? and inverter_sn <> '' and status is not null
+		order by inverter_sn, read_time`, stationID, from.UTC(), to.UTC())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var annotations []GrafanaAnnotation
+	open := -1
+	lastSN := ""
+	for rows.Next() {
+		var sn, name string
+		var readTime time.Time
+		var status int
+		if err := rows.Scan(&sn, &name, &readTime, &status); err != nil {
+			return nil, err
+		}
+		if sn != lastSN {
+			open, lastSN = -1, sn
+		}
+		switch {
+		case status == 2 && open < 0:
+			annotations = append(annotations, GrafanaAnnotation{
+				Time:  readTime.UnixMilli(),
+				Title: "Inverter fault",
+				Text:  orDefault(name, sn) + " reported a fault",
+				Tags:  []string{"fault", sn},
+			})
+			open = len(annotations) - 1
+		case status != 2 && open >= 0:
+			annotations[open].TimeEnd = readTime.UnixMilli()
+			open = -1
+		}
+	}
+	return annotations, rows.Err()
+}
+
+// weatherAnnotations reports each change of the weather condition.
+func (s *Store) weatherAnnotations(stationID string, from time.Time, to time.Time) ([]GrafanaAnnotation, error) {
+	if err := s.ensureSchema(); err != nil {
+		return nil, err
+	}
+	rows, err := s.db.Query(`select observed_at, coalesce(weather, ''), coalesce(weather_description, '') from weather_readings
+		where station_id = ? and observed_at >= ? and observed_at < ? and coalesce(weather, '') <> ''
+		order by observed_at`, stationID, from.UTC(), to.UTC())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var annotations []GrafanaAnnotation
+	last := ""
+	for rows.Next() {
+		var observedAt time.Time
+		var condition, description string
+		if err := rows.Scan(&observedAt, &condition, &description); err != nil {
+			return nil, err
+		}
+		if last != "" && condition != last {
+			annotations = append(annotations, GrafanaAnnotation{
+				Time:  observedAt.UnixMilli(),
+				Title: last + " → " + condition,
+				Text:  description,
+				Tags:  []string{"weather", condition},
+			})
+		}
+		last = condition
+	}
+	return annotations, rows.Err()
+}
+
+// decodeGrafana reads a request body, answering 400 when it is malformed.
+func decodeGrafana(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		writeJSON(w, http.StatusBadRequest, ErrorV2{Error: "request body: " + err.Error()})
+		return false
+	}
+	return true
+}
+
+func grafanaSearchHandler(configs *ConfigSource, store *Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req GrafanaSearchRequest
+		if r.ContentLength != 0 && !decodeGrafana(w, r, &req) {
+			return
+		}
+		var names []string
+		for _, metric := range grafanaMetrics {
+			names = append(names, metric.Name)
+		}
+		if store != nil {
+			serials, err := store.recentInverters(configs.Get().ClientConfig.StationInfo.StationID)
+			if err != nil {
+				writeJSON(w, http.StatusInternalServerError, ErrorV2{Error: err.Error()})
+				return
+			}
+			for _, metric := range grafanaMetrics {
+				for _, sn := range serials {
+					if metric.Inverter {
+						names = append(names, metric.Name+":"+sn)
+					}
+				}
+			}
+		}
+		matches := []string{}
+		for _, name := range names {
+			if strings.Contains(name, req.Target) {
+				matches = append(matches, name)
+			}
+		}
+		writeJSON(w, http.StatusOK, matches)
+	}
+}
+
+func grafanaQueryHandler(configs *ConfigSource, store *Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if store == nil {
+			writeJSON(w, http.StatusServiceUnavailable, ErrorV2{Error: "grafana queries need database.dsn to be configured"})
+			return
+		}
+		var req GrafanaQueryRequest
+		if !decodeGrafana(w, r, &req) {
+			return
+		}
+		step := time.Duration(req.IntervalMs) * time.Millisecond
+		if step < time.Minute {
+			step = time.Minute
+		}
+		results := []GrafanaQueryResult{}
+		for _, target := range req.Targets {
+			name, sn, _ := strings.Cut(target.Target, ":")
+			metric, ok := findGrafanaMetric(name)
+			if !ok || (sn != "" && !metric.Inverter) {
+				writeJSON(w, http.StatusBadRequest, ErrorV2{Error: "unknown target " + target.Target})
+				return
+			}
+			stationID := configs.Get().ClientConfig.StationInfo.StationID
+			for _, options := range []*GrafanaOptions{target.Data, target.Payload} {
+				if options != nil && options.Station != "" {
+					stationID = options.Station
+				}
+			}
+			var points []seriesPoint
+			var err error
+			if metric.Inverter {
+				points, err = store.inverterSeries(stationID, metric.Column, sn, metric.Sum, req.Range.From, req.Range.To, step)
+			} else {
+				points, err = store.weatherSeries(stationID, metric.Column, req.Range.From, req.Range.To, step)
+			}
+			if err != nil {
+				writeJSON(w, http.StatusInternalServerError, ErrorV2{Error: err.Error()})
+				return
+			}
+			results = append(results, grafanaResult(target, points))
+		}
+		writeJSON(w, http.StatusOK, results)
+	}
+}
+
+func grafanaResult(target GrafanaTarget, points []seriesPoint) GrafanaQueryResult {
+	if target.Type == "table" {
+		result := GrafanaQueryResult{
+			Type:    "table",
+			Columns: []GrafanaColumn{{Text: "Time", Type: "time"}, {Text: target.Target, Type: "number"}},
+			Rows:    [][]interface{}{},
+		}
+		for _, point := range points {
+			result.Rows = append(result.Rows, []interface{}{point.Time.UnixMilli(), point.Value})
+		}
+		return result
+	}
+	result := GrafanaQueryResult{Target: target.Target, Datapoints: [][2]float64{}}
+	for _, point := range points {
+		result.Datapoints = append(result.Datapoints, [2]float64{point.Value, float64(point.Time.UnixMilli())})
+	}
+	return result
+}
+
+func grafanaAnnotationsHandler(configs *ConfigSource, store *Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if store == nil {
+			writeJSON(w, http.StatusServiceUnavailable, ErrorV2{Error: "grafana annotations need database.dsn to be configured"})
+			return
+		}
+		var req GrafanaAnnotationRequest
+		if !decodeGrafana(w, r, &req) {
+			return
+		}
+		stationID := configs.Get().ClientConfig.StationInfo.StationID
+		query := strings.TrimSpace(req.Annotation.Query)
+		if query != "" && query != "faults" && query != "weather" {
+			writeJSON(w, http.StatusBadRequest, ErrorV2{Error: "annotation query must be faults, weather or empty"})
+			return
+		}
+		annotations := []GrafanaAnnotation{}
+		if query != "weather" {
+			faults, err := store.faultAnnotations(stationID, req.Range.From, req.Range.To)
+			if err != nil {
+				writeJSON(w, http.StatusInternalServerError, ErrorV2{Error: err.Error()})
+				return
+			}
+			annotations = append(annotations, faults...)
+		}
+		if query != "faults" {
+			changes, err := store.weatherAnnotations(stationID, req.Range.From, req.Range.To)
+			if err != nil {
+				writeJSON(w, http.StatusInternalServerError, ErrorV2{Error: err.Error()})
+				return
+			}
+			annotations = append(annotations, changes...)
+		}
+		for i := range annotations {
+			annotations[i].Annotation = req.Annotation
+		}
+		sort.Slice(annotations, func(i, j int) bool { return annotations[i].Time < annotations[j].Time })
+		writeJSON(w, http.StatusOK, annotations)
+	}
+}
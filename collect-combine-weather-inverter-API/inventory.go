@@ -0,0 +1,338 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// snapshotInventory lists the equipment details of a snapshot by serial
+// number, with the station itself under "". Blank values are left out so a
+// poll where SEMS omits a field does not look like a change.
+func snapshotInventory(snapshot Snapshot) map[string]map[string]string {
+	info := snapshot.InverterData.Data.Info
+	inventory := map[string]map[string]string{
+		"": {
+			"name":                 info.Stationname,
+			"address":              info.Address,
+			"owner":                info.OwnerName,
+			"create_time":          info.CreateTime,
+			"type":                 info.PowerstationType,
+			"capacity_kw":          formatInventoryNumber(info.Capacity),
+			"battery_capacity_kwh": formatInventoryNumber(info.BatteryCapacity),
+		},
+	}
+	for _, inverter := range snapshot.InverterData.Data.Inverter {
+		if inverter.Sn == "" {
+			continue
+		}
+		firmware := inverter.D.FirmwareVersion
+		if firmware == 0 {
+			firmware = inverter.InvertFull.Firmwareversion
+		}
+		if firmware == 0 {
+			firmware = inverter.FirmwareVersion
+		}
+		inventory[inverter.Sn] = map[string]string{
+			"name":             inverter.Name,
+			"model":            inverter.D.Model,
+			"model_type":       inverter.InvertFull.ModelType,
+			"firmware_version": formatInventoryNumber(firmware),
+			"datalogger_sn":    inverter.InvertFull.Dataloggersn,
+			"creation_date":    inverter.D.CreationDate,
+			"capacity_kw":      formatInventoryNumber(inverter.Capacity),
+		}
+	}
+	// Equipment also lists meters and batteries; only the inverters' limits
+	// are kept.
+	for _, equipment := range snapshot.InverterData.Data.Equipment {
+		if fields, ok := inventory[equipment.Sn]; ok && equipment.Sn != "" {
+			fields["export_power_limit"] = formatInventoryValue(equipment.ExportPowerlimit)
+			fields["target_pf"] = formatInventoryValue(equipment.TargetPF)
+		}
+	}
+	for _, fields := range inventory {
+		for field, value := range fields {
+			if value = strings.TrimSpace(value); value == "" {
+				delete(fields, field)
+			} else {
+				fields[field] = value
+			}
+		}
+	}
+	return inventory
+}
+
+func formatInventoryNumber(value float64) string {
+	if value == 0 {
+		return ""
+	}
+	return strconv.FormatFloat(value, 'f', -1, 64)
+}
+
+// formatInventoryValue formats the loosely typed Equipment fields, which
+// SEMS sends as numbers, strings or null.
+func formatInventoryValue(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case float64:
+		return formatInventoryNumber(v)
+	}
+	return fmt.Sprint(value)
+}
+
+type inventoryValue struct {
+	value    string
+	lastSeen time.Time
+}
+
+// saveInventory keeps one row per value a field has had. The current value
+// has no replaced_at and its last_seen moves with every poll; a new value
+// closes it and starts a new row. Equipment that disappears keeps its last
+// values with the time it was last seen.
+func saveInventory(tx *sql.Tx, snapshot storedSnapshot) error {
+	rows, err := tx.Query(`select equipment_sn, field, value, last_seen from equipment_inventory
+		where station_id = ? and replaced_at is null`, snapshot.StationID)
+	if err != nil {
+		return fmt.Errorf("read inventory: %v", err)
+	}
+	current := make(map[[2]string]inventoryValue)
+	for rows.Next() {
+		var sn, field string
+		var v inventoryValue
+		if err := rows.Scan(&sn, &field, &v.value, &v.lastSeen); err != nil {
+			rows.Close()
+			return fmt.Errorf("read inventory: %v", err)
+		}
+		current[[2]string{sn, field}] = v
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("read inventory: %v", err)
+	}
+
+	at := snapshot.CollectedAt
+	for sn, fields := range snapshot.Inventory {
+		for field, value := range fields {
+			previous, ok := current[[2]string{sn, field}]
+			if ok && previous.value == value {
+				continue
+			}
+			// Buffered snapshots replayed after a newer value was stored are
+			// not allowed to undo it.
+			if ok && at.Before(previous.lastSeen) {
+				continue
+			}
+			if ok {
+				_, err = tx.Exec(`update equipment_inventory set replaced_at = ?
+					where station_id = ? and equipment_sn = ? and field = ? and replaced_at is null`,
+					at, snapshot.StationID, sn, field)
+				if err != nil {
+					return fmt.Errorf("update inventory: %v", err)
+				}
+			}
+			_, err = tx.Exec(`insert into equipment_inventory (station_id, equipment_sn, field, value, first_seen, last_seen)
+				values (?, ?, ?, ?, ?, ?)`, snapshot.StationID, sn, field, value, at, at)
+			if err != nil {
+				return fmt.Errorf("insert inventory: %v", err)
+			}
+		}
+		if len(fields) > 0 {
+			_, err = tx.Exec(`update equipment_inventory set last_seen = ?
+				where station_id = ? and equipment_sn = ? and replaced_at is null and last_seen < ?`,
+				at, snapshot.StationID, sn, at)
+			if err != nil {
+				return fmt.Errorf("update inventory: %v", err)
+			}
+		}
+	}
+	return nil
+}
+
+type InventoryV2 struct {
+	Stations []InventoryStationV2 `json:"stations"`
+}
+
+type InventoryStationV2 struct {
+	StationID          string                 `json:"station_id"`
+	Name               string                 `json:"name"`
+	Address            string                 `json:"address"`
+	Owner              string                 `json:"owner"`
+	CreateTime         string                 `json:"create_time" doc:"When the station was created in SEMS, as SEMS formats it."`
+	Type               string                 `json:"type" doc:"SEMS powerstation_type."`
+	CapacityKW         float64                `json:"capacity_kw" unit:"kW"`
+	BatteryCapacityKWh float64                `json:"battery_capacity_kwh" unit:"kWh"`
+	FirstSeen          time.Time              `json:"first_seen"`
+	LastSeen           time.Time              `json:"last_seen"`
+	Changes            []InventoryChangeV2    `json:"changes" doc:"Changes to the fields above, oldest first."`
+	Equipment          []InventoryEquipmentV2 `json:"equipment"`
+}
+
+type InventoryEquipmentV2 struct {
+	SerialNumber     string              `json:"serial_number"`
+	Name             string              `json:"name"`
+	Model            string              `json:"model"`
+	ModelType        string              `json:"model_type"`
+	FirmwareVersion  string              `json:"firmware_version"`
+	DataloggerSN     string              `json:"datalogger_sn"`
+	CreationDate     string              `json:"creation_date" doc:"As SEMS formats it."`
+	CapacityKW       float64             `json:"capacity_kw" unit:"kW"`
+	ExportPowerLimit string              `json:"export_power_limit" doc:"As SEMS reports it; empty when not set."`
+	TargetPF         string              `json:"target_pf" doc:"Target power factor, as SEMS reports it."`
+	FirstSeen        time.Time           `json:"first_seen"`
+	LastSeen         time.Time           `json:"last_seen" doc:"Last poll that reported this equipment. Older than the station's last_seen once it was removed."`
+	Changes          []InventoryChangeV2 `json:"changes" doc:"Changes to the fields above, such as firmware upgrades or datalogger swaps, oldest first."`
+}
+
+type InventoryChangeV2 struct {
+	Field     string    `json:"field" doc:"JSON key of the field that changed."`
+	From      string    `json:"from"`
+	To        string    `json:"to"`
+	ChangedAt time.Time `json:"changed_at" doc:"First poll that reported the new value."`
+}
+
+type inventoryRow struct {
+	stationID, sn, field, value string
+	firstSeen, lastSeen         time.Time
+	current                     bool
+}
+
+func (s *Store) inventory(stationID string) (InventoryV2, error) {
+	if err := s.ensureSchema(); err != nil {
+		return InventoryV2{}, err
+	}
+	rows, err := s.db.Query(`select station_id, equipment_sn, field, value, first_seen, last_seen, replaced_at is null
+		from equipment_inventory where ? = '' or station_id = ?
+		order by station_id, equipment_sn, field, first_seen`, stationID, stationID)
+	if err != nil {
+		return InventoryV2{}, err
+	}
+	defer rows.Close()
+	var list []inventoryRow
+	for rows.Next() {
+		var r inventoryRow
+		if err := rows.Scan(&r.stationID, &r.sn, &r.field, &r.value, &r.firstSeen, &r.lastSeen, &r.current); err != nil {
+			return InventoryV2{}, err
+		}
+		list = append(list, r)
+	}
+	if err := rows.Err(); err != nil {
+		return InventoryV2{}, err
+	}
+	return buildInventory(list), nil
+}
+
+// buildInventory turns rows ordered by station, equipment, field and
+// first_seen into the current values and change history of each device.
+func buildInventory(rows []inventoryRow) InventoryV2 {
+	inventory := InventoryV2{Stations: []InventoryStationV2{}}
+	var station *InventoryStationV2
+	var equipment *InventoryEquipmentV2
+	for i, r := range rows {
+		if station == nil || station.StationID != r.stationID {
+			inventory.Stations = append(inventory.Stations, InventoryStationV2{
+				StationID: r.stationID, Changes: []InventoryChangeV2{}, Equipment: []InventoryEquipmentV2{}})
+			station = &inventory.Stations[len(inventory.Stations)-1]
+			equipment = nil
+		}
+		firstSeen, lastSeen, changes := &station.FirstSeen, &station.LastSeen, &station.Changes
+		if r.sn != "" {
+			if equipment == nil || equipment.SerialNumber != r.sn {
+				station.Equipment = append(station.Equipment, InventoryEquipmentV2{SerialNumber: r.sn, Changes: []InventoryChangeV2{}})
+				equipment = &station.Equipment[len(station.Equipment)-1]
+			}
+			firstSeen, lastSeen, changes = &equipment.FirstSeen, &equipment.LastSeen, &equipment.Changes
+		}
+		if firstSeen.IsZero() || r.firstSeen.Before(*firstSeen) {
+			*firstSeen = r.firstSeen
+		}
+		if r.lastSeen.After(*lastSeen) {
+			*lastSeen = r.lastSeen
+		}
+		if i > 0 && rows[i-1].stationID == r.stationID && rows[i-1].sn == r.sn && rows[i-1].field == r.field {
+			*changes = append(*changes, InventoryChangeV2{Field: r.field, From: rows[i-1].value, To: r.value, ChangedAt: r.firstSeen})
+		}
+		if !r.current {
+			continue
+		}
+		if r.sn == "" {
+			station.setField(r.field, r.value)
+		} else {
+			equipment.setField(r.field, r.value)
+		}
+	}
+	for i := range inventory.Stations {
+		sortChanges(inventory.Stations[i].Changes)
+		for j := range inventory.Stations[i].Equipment {
+			sortChanges(inventory.Stations[i].Equipment[j].Changes)
+		}
+	}
+	return inventory
+}
+
+func sortChanges(changes []InventoryChangeV2) {
+	sort.SliceStable(changes, func(i, j int) bool { return changes[i].ChangedAt.Before(changes[j].ChangedAt) })
+}
+
+func (s *InventoryStationV2) setField(field string, value string) {
+	number, _ := strconv.ParseFloat(value, 64)
+	switch field {
+	case "name":
+		s.Name = value
+	case "address":
+		s.Address = value
+	case "owner":
+		s.Owner = value
+	case "create_time":
+		s.CreateTime = value
+	case "type":
+		s.Type = value
+	case "capacity_kw":
+		s.CapacityKW = number
+	case "battery_capacity_kwh":
+		s.BatteryCapacityKWh = number
+	}
+}
+
+func (e *InventoryEquipmentV2) setField(field string, value string) {
+	switch field {
+	case "name":
+		e.Name = value
+	case "model":
+		e.Model = value
+	case "model_type":
+		e.ModelType = value
+	case "firmware_version":
+		e.FirmwareVersion = value
+	case "datalogger_sn":
+		e.DataloggerSN = value
+	case "creation_date":
+		e.CreationDate = value
+	case "capacity_kw":
+		e.CapacityKW, _ = strconv.ParseFloat(value, 64)
+	case "export_power_limit":
+		e.ExportPowerLimit = value
+	case "target_pf":
+		e.TargetPF = value
+	}
+}
+
+func inventoryHandler(store *Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if store == nil {
+			writeJSON(w, http.StatusServiceUnavailable, ErrorV2{Error: "inventory needs database.dsn to be configured"})
+			return
+		}
+		inventory, err := store.inventory(r.URL.Query().Get("station"))
+		if err != nil {
+			writeJSON(w, http.StatusInternalServerError, ErrorV2{Error: err.Error()})
+			return
+		}
+		writeJSON(w, http.StatusOK, inventory)
+	}
+}
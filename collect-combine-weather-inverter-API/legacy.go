@@ -0,0 +1,269 @@
+package main
+
+import (
+	"database/sql"
+	"flag"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+const legacyTimeLayout = "2006-01-02 15:04:05"
+
+// LegacyRow is a row of the inverter_data table written by the PowerShell
+// collector. Times are the raw strings it stored.
+type LegacyRow struct {
+	InverterName       string
+	Capacity           float64
+	OutputPower        float64
+	EnergyDay          float64
+	EnergyMonth        float64
+	EnergyTotal        float64
+	ReadTime           string
+	BootTime           string
+	CurrentTemperature float64
+	CloudPercent       int
+	WeatherType        string
+	WeatherDescription string
+	Sunrise            string
+	Sunset             string
+	ClearSkyOutput     float64
+	PerformanceRatio   float64
+	SpecificYield      float64
+}
+
+type legacyImport struct {
+	table     string
+	stationID string
+	loc       *time.Location
+	utcSince  string
+	batchSize int
+}
+
+// location returns the zone a legacy time was written in: rows from after
+// the PowerShell script switched to UTC carry UTC times.
+func (imp legacyImport) location(readTime string) *time.Location {
+	if imp.utcSince != "" && readTime >= imp.utcSince {
+		return time.UTC
+	}
+	return imp.loc
+}
+
+func runImportLegacy(args []string) {
+	config := importConfig()
+	flags := flag.NewFlagSet("import-legacy", flag.ExitOnError)
+	legacyDSN := flags.String("legacy-dsn", config.Database.DSN, "DSN of the database holding the PowerShell table")
+	table := flags.String("table", "inverter_data", "legacy table name")
+	stationID := flags.String("station", config.ClientConfig.StationInfo.StationID, "station ID to file the imported readings under")
+	timezone := flags.String("timezone", config.ClientConfig.Timezone, "IANA timezone the legacy times were written in (default: local)")
+	utcSince := flags.String("utc-since", "", "first read_time (YYYY-MM-DD HH:MM:SS) written in UTC by the updated PowerShell script")
+	batchSize := flags.Int("batch", 1000, "rows per transaction")
+	flags.Parse(args)
+
+	if config.Database.DSN == "" {
+		log.Fatal("import-legacy: database.dsn is not configured")
+	}
+	loc := time.Local
+	if *timezone != "" {
+		var err error
+		if loc, err = time.LoadLocation(*timezone); err != nil {
+			log.Fatalf("import-legacy: --timezone: %v", err)
+		}
+	}
+	store, err := openStore(config.Database.DSN)
+	if err != nil {
+		log.Fatalf("import-legacy: %v", err)
+	}
+	defer store.Close()
+	legacy, err := sql.Open("mysql", *legacyDSN)
+	if err != nil {
+		log.Fatalf("import-legacy: --legacy-dsn: %v", err)
+	}
+	defer legacy.Close()
+
+	imp := legacyImport{table: *table, stationID: *stationID, loc: loc, utcSince: *utcSince, batchSize: *batchSize}
+	total, err := store.importLegacy(legacy, imp)
+	if err != nil {
+		log.Fatalf("import-legacy: %v (imported %d rows, rerun to resume)", err, total)
+	}
+	logger.Info("import-legacy finished", "rows", total)
+}
+
+// importLegacy copies the legacy table into inverter_readings and
+// weather_readings in read_time order. Progress is checkpointed in
+// import_progress inside each batch's transaction so an interrupted import
+// resumes where it stopped; unique keys make re-imported rows no-ops.
+func (s *Store) importLegacy(legacy *sql.DB, imp legacyImport) (int, error) {
+	if err := s.ensureSchema(); err != nil {
+		return 0, err
+	}
+	if strings.ContainsAny(imp.table, "`;' ") {
+		return 0, fmt.Errorf("invalid table name %q", imp.table)
+	}
+	columns, err := legacyColumns(legacy, imp.table)
+	if err != nil {
+		return 0, err
+	}
+	serials, err := s.inverterSerials(imp.stationID)
+	if err != nil {
+		return 0, err
+	}
+	checkpointName := "legacy:" + imp.table
+	position := "1000-01-01 00:00:00"
+	err = s.db.QueryRow(`select position from import_progress where name = ?`, checkpointName).Scan(&position)
+	if err != nil && err != sql.ErrNoRows {
+		return 0, err
+	}
+
+	optional := func(column string) string {
+		if columns[column] {
+			return "coalesce(" + column + ", 0)"
+		}
+		return "0"
+	}
+	query := fmt.Sprintf(`select coalesce(inverter_name, ''), coalesce(inverter_capacity, 0), coalesce(inverter_current, 0),
+		coalesce(inverter_day_total, 0), coalesce(inverter_month_total, 0), coalesce(inverter_total, 0),
+		cast(read_time as char), coalesce(cast(boot_time as char), ''), coalesce(current_temp, 0), coalesce(cloud_percent, 0),
+		coalesce(weather, ''), coalesce(weather_description, ''), coalesce(cast(sunrise as char), ''), coalesce(cast(sunset as char), ''),
+		%s, %s, %s
+		from `+"`%s`"+` where read_time > ? order by read_time limit ?`,
+		optional("clear_sky_output"), optional("performance_ratio"), optional("specific_yield"), imp.table)
+
+	total := 0
+	for {
+		batch, err := readLegacyBatch(legacy, query, position, imp.batchSize)
+		if err != nil {
+			return total, err
+		}
+		if len(batch) == 0 {
+			return total, nil
+		}
+		if err := s.saveLegacyBatch(batch, imp, serials, checkpointName); err != nil {
+			return total, err
+		}
+		total += len(batch)
+		position = batch[len(batch)-1].ReadTime
+		logger.Info("import-legacy progress", "rows", total, "position", position)
+	}
+}
+
+func legacyColumns(legacy *sql.DB, table string) (map[string]bool, error) {
+	rows, err := legacy.Query("select * from `" + table + "` limit 0")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	names, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+	columns := make(map[string]bool)
+	for _, name := range names {
+		columns[strings.ToLower(name)] = true
+	}
+	return columns, nil
+}
+
+func readLegacyBatch(legacy *sql.DB, query string, position string, limit int) ([]LegacyRow, error) {
+	rows, err := legacy.Query(query, position, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var batch []LegacyRow
+	for rows.Next() {
+		var row LegacyRow
+		err := rows.Scan(&row.InverterName, &row.Capacity, &row.OutputPower, &row.EnergyDay, &row.EnergyMonth,
+			&row.EnergyTotal, &row.ReadTime, &row.BootTime, &row.CurrentTemperature, &row.CloudPercent,
+			&row.WeatherType, &row.WeatherDescription, &row.Sunrise, &row.Sunset,
+			&row.ClearSkyOutput, &row.PerformanceRatio, &row.SpecificYield)
+		if err != nil {
+			return nil, err
+		}
+		batch = append(batch, row)
+	}
+	return batch, rows.Err()
+}
+
+// inverterSerials maps inverter names to the serial numbers the live
+// collector has stored, since the legacy table only kept names.
+func (s *Store) inverterSerials(stationID string) (map[string]string, error) {
+	rows, err := s.db.Query(`select distinct inverter_name, inverter_sn from inverter_readings
+		where station_id = ? and inverter_sn <> '' and inverter_name is not null`, stationID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	serials := make(map[string]string)
+	for rows.Next() {
+		var name, sn string
+		if err := rows.Scan(&name, &sn); err != nil {
+			return nil, err
+		}
+		serials[name] = sn
+	}
+	return serials, rows.Err()
+}
+
+func (s *Store) saveLegacyBatch(batch []LegacyRow, imp legacyImport, serials map[string]string, checkpointName string) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	now := time.Now().UTC()
+	for _, row := range batch {
+		loc := imp.location(row.ReadTime)
+		readTime := parseLegacyTime(row.ReadTime, loc)
+		if readTime.IsZero() {
+			continue
+		}
+		res, err := tx.Exec(`insert into weather_readings (station_id, provider, observed_at, temperature, cloud_percent,
+			weather, weather_description, sunrise, sunset)
+			values (?, 'legacy', ?, ?, ?, ?, ?, ?, ?)
+			on duplicate key update id = last_insert_id(id)`,
+			imp.stationID, readTime, row.CurrentTemperature, row.CloudPercent, row.WeatherType, row.WeatherDescription,
+			nullTime(parseLegacyTime(row.Sunrise, loc)), nullTime(parseLegacyTime(row.Sunset, loc)))
+		if err != nil {
+			return fmt.Errorf("import weather at %s: %v", row.ReadTime, err)
+		}
+		weatherID, err := res.LastInsertId()
+		if err != nil {
+			return err
+		}
+		sn := serials[row.InverterName]
+		if sn == "" {
+			sn = row.InverterName
+		}
+		_, err = tx.Exec(`insert ignore into inverter_readings (station_id, inverter_sn, inverter_name, capacity, read_time,
+			boot_time, collected_at, output_power, energy_day, energy_month, energy_total, clear_sky_output,
+			performance_ratio, specific_yield, weather_id, source)
+			values (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, 'legacy')`,
+			imp.stationID, sn, row.InverterName, row.Capacity, readTime, nullTime(parseLegacyTime(row.BootTime, loc)),
+			now, row.OutputPower, row.EnergyDay, row.EnergyMonth, row.EnergyTotal, row.ClearSkyOutput,
+			row.PerformanceRatio, row.SpecificYield, weatherID)
+		if err != nil {
+			return fmt.Errorf("import reading at %s: %v", row.ReadTime, err)
+		}
+	}
+	_, err = tx.Exec(`insert into import_progress (name, position, rows_imported, updated_at) values (?, ?, ?, ?)
+		on duplicate key update position = values(position), rows_imported = rows_imported + values(rows_imported),
+		updated_at = values(updated_at)`,
+		checkpointName, batch[len(batch)-1].ReadTime, len(batch), now)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// parseLegacyTime reads a time the PowerShell script wrote in loc. The
+// script's fallback for unparseable values was the Unix epoch, which is
+// treated as unknown.
+func parseLegacyTime(value string, loc *time.Location) time.Time {
+	t, err := time.ParseInLocation(legacyTimeLayout, value, loc)
+	if err != nil || t.Year() <= 1970 {
+		return time.Time{}
+	}
+	return t.UTC()
+}
@@ -1,129 +1,79 @@
 package main
 
 import (
-	"bytes"
 	"encoding/json"
-	"io/ioutil"
 	"net/http"
-	"os"
-
-	"github.com/gorilla/mux"
 )
 
-func newRouter() *mux.Router {
-	r := mux.NewRouter()
-	r.HandleFunc("/getinverterdata", getInverterDataHandler).Methods("GET")
-	return r
-}
-
 func main() {
-	r := newRouter()
-	err := http.ListenAndServe(":22222", r)
-	if err != nil {
-		panic(err.Error())
-	}
+	runCLI()
 }
 
-func getInverterDataHandler(w http.ResponseWriter, r *http.Request) {
-	config := importConfig()
-	inverterData := getInverterData(config, runLoginRequest(config))
-	weatherData := getWeatherData(config)
-	inverter := inverterData.Data.Inverter[0]
+func getInverterDataHandler(configs *ConfigSource) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		snapshot, err := collectSnapshot(configs.Get())
+		checkErr(err)
+		inverter := snapshot.Readings[0]
+		weather := snapshot.Weather
+		// v1 passes SEMS times through untouched; v2 has them in UTC.
+		sems := snapshot.InverterData.Data.Inverter[0]
 
-	response := ResponseData{
-		InverterName:       inverter.Name,
-		InverterCapacity:   inverter.Capacity,
-		EnergyCurrent:      inverter.D.Pac,
-		EnergyDay:          inverter.Eday,
-		EnergyMonth:        inverter.Emonth,
-		EnergyTotal:        inverter.Etotal,
-		LastRead:           inverter.Time,
-		OnlineSince:        inverter.TurnonTime,
-		CurrentTemperature: weatherData.Main.Temp,
-		CloudPercent:       weatherData.Clouds.All,
-		WeatherType:        weatherData.Weather[0].Main,
-		WeatherDescription: weatherData.Weather[0].Description,
-		Sunrise:            weatherData.Sys.Sunrise,
-		Sunset:             weatherData.Sys.Sunset,
+		response := ResponseData{
+			InverterName:       inverter.InverterName,
+			InverterCapacity:   inverter.Capacity,
+			EnergyCurrent:      inverter.OutputPower,
+			EnergyDay:          inverter.EnergyDay,
+			EnergyMonth:        inverter.EnergyMonth,
+			EnergyTotal:        inverter.EnergyTotal,
+			LastRead:           sems.Time,
+			OnlineSince:        sems.TurnonTime,
+			CurrentTemperature: weather.Temperature,
+			CloudPercent:       weather.CloudPercent,
+			WeatherType:        weather.Type,
+			WeatherDescription: weather.Description,
+			Sunrise:            weather.Sunrise,
+			Sunset:             weather.Sunset,
+			GHI:                weather.GHI,
+			DNI:                weather.DNI,
+			DHI:                weather.DHI,
+			ClearSkyOutput:     inverter.Performance.ClearSkyOutput,
+			PerformanceRatio:   inverter.Performance.PerformanceRatio,
+			SpecificYield:      inverter.Performance.SpecificYield,
+		}
+		responseBytes, err := json.Marshal(response)
+		checkErr(err)
+		w.Write(responseBytes)
 	}
-	responseBytes, err := json.Marshal(response)
-	checkErr(err)
-	w.Write(responseBytes)
 }
 
-func importConfig() Config {
-	data, err := os.Open("config.json")
-	checkErr(err)
-	defer data.Close()
-	byteVal, _ := ioutil.ReadAll(data)
-	var config Config
-	json.Unmarshal(byteVal, &config)
-	return config
-}
-
-func getWeatherData(config Config) WeatherData {
-	client := &http.Client{}
-	req, err := http.NewRequest("GET", config.WeatherAPI.BaseURL+"zip="+config.WeatherAPI.ZipCode+","+config.WeatherAPI.CountryCode+"&appid="+config.WeatherAPI.AppID+"&units=metric", nil)
-	checkErr(err)
-	resp, err := client.Do(req)
-	checkErr(err)
-	defer resp.Body.Close()
-	var weatherData WeatherData
-	if resp.StatusCode == http.StatusOK {
-		bodyBytes, err := ioutil.ReadAll(resp.Body)
+func getDailySummaryHandler(configs *ConfigSource) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		snapshot, err := collectSnapshot(configs.Get())
+		checkErr(err)
+		responseBytes, err := json.Marshal(snapshotDailySummary(snapshot))
 		checkErr(err)
-		json.Unmarshal(bodyBytes, &weatherData)
+		w.Write(responseBytes)
 	}
-	return weatherData
 }
 
-func getInverterData(config Config, LoginResponse LoginResponse) InverterData {
-	client := &http.Client{}
-	postData := config.ClientConfig.StationInfo
-	b, err := json.Marshal(postData)
-	checkErr(err)
-	req, err := http.NewRequest("POST", config.APIConfig.BaseURL+config.APIConfig.InverterURL, bytes.NewReader(b))
-	checkErr(err)
-	tokenByte, err := json.Marshal(LoginResponse.Data)
-	checkErr(err)
-	tokenString := string(tokenByte)
-	req.Header.Add("Content-Type", "application/json")
-	req.Header.Add("Accept", "application/json")
-	req.Header.Add("token", tokenString)
-	resp, err := client.Do(req)
-	checkErr(err)
-	defer resp.Body.Close()
-	var inverterData InverterData
-	if resp.StatusCode == http.StatusOK {
-		bodyBytes, err := ioutil.ReadAll(resp.Body)
-		checkErr(err)
-		json.Unmarshal(bodyBytes, &inverterData)
+func snapshotDailySummary(snapshot Snapshot) DailySummary {
+	info := snapshot.InverterData.Data.Info
+	var energyDay float64
+	for _, reading := range snapshot.Readings {
+		energyDay += reading.EnergyDay
 	}
-	return inverterData
+	return dailySummary(snapshot.CollectedAt, snapshot.Location, info.Latitude, info.Longitude, stationCapacity(snapshot.InverterData), energyDay)
 }
 
-func runLoginRequest(config Config) LoginResponse {
-	client := &http.Client{}
-	postData := config.ClientConfig.LoginInfo
-	b, err := json.Marshal(postData)
-	checkErr(err)
-	req, err := http.NewRequest("POST", config.APIConfig.BaseURL+config.APIConfig.LoginURL, bytes.NewReader(b))
-	checkErr(err)
-	tokenByte, err := json.Marshal(config.APIConfig.LoginToken)
-	checkErr(err)
-	tokenString := string(tokenByte)
-	req.Header.Add("Content-Type", "application/json")
-	req.Header.Add("token", tokenString)
-	resp, err := client.Do(req)
-	checkErr(err)
-	defer resp.Body.Close()
-	var loginResponse LoginResponse
-	if resp.StatusCode == http.StatusOK {
-		bodyBytes, err := ioutil.ReadAll(resp.Body)
-		checkErr(err)
-		json.Unmarshal(bodyBytes, &loginResponse)
+func stationCapacity(inverterData InverterData) float64 {
+	if inverterData.Data.Info.Capacity > 0 {
+		return inverterData.Data.Info.Capacity
+	}
+	var capacity float64
+	for _, inverter := range inverterData.Data.Inverter {
+		capacity += inverter.Capacity
 	}
-	return loginResponse
+	return capacity
 }
 
 func checkErr(e error) {
@@ -134,40 +84,49 @@ func checkErr(e error) {
 
 type ResponseData struct {
 	InverterName       string  `json:"name"`
-	InverterCapacity   float64 `json:"capacity"`
-	EnergyCurrent      float64 `json:"currentoutput"`
-	EnergyDay          float64 `json:"dayoutput"`
-	EnergyMonth        float64 `json:"monthOutput"`
-	EnergyTotal        float64 `json:"totaloutput"`
-	LastRead           string  `json:"readtime"`
-	OnlineSince        string  `json:boottime`
-	CurrentTemperature float64 `json:"currenttemp"`
-	CloudPercent       int     `json:"cloudpercent"`
+	InverterCapacity   float64 `json:"capacity" unit:"kW"`
+	EnergyCurrent      float64 `json:"currentoutput" unit:"W"`
+	EnergyDay          float64 `json:"dayoutput" unit:"kWh"`
+	EnergyMonth        float64 `json:"monthOutput" unit:"kWh"`
+	EnergyTotal        float64 `json:"totaloutput" unit:"kWh"`
+	LastRead           string  `json:"readtime" doc:"As SEMS reports it, in the station's local time and date format."`
+	OnlineSince        string  `json:"boottime" doc:"As SEMS reports it."`
+	CurrentTemperature float64 `json:"currenttemp" unit:"°C"`
+	CloudPercent       int     `json:"cloudpercent" unit:"%"`
 	WeatherType        string  `json:"weather"`
 	WeatherDescription string  `json:"weatherdesc"`
-	Sunrise            int     `json:"sunrise"`
-	Sunset             int     `json:"sunset"`
+	Sunrise            int     `json:"sunrise" unit:"s" doc:"Unix time."`
+	Sunset             int     `json:"sunset" unit:"s" doc:"Unix time."`
+	GHI                float64 `json:"ghi" unit:"W/m²"`
+	DNI                float64 `json:"dni" unit:"W/m²"`
+	DHI                float64 `json:"dhi" unit:"W/m²"`
+	ClearSkyOutput     float64 `json:"clearskyoutput" unit:"W"`
+	PerformanceRatio   float64 `json:"performanceratio" unit:"1"`
+	SpecificYield      float64 `json:"specificyield" unit:"kWh/kWp" doc:"Energy since the inverter's previous SEMS reading per kW of capacity."`
 }
 
 type ClientConfig struct {
-	LoginInfo   LoginInfo   `json:"loginInfo"`
+	LoginInfo   LoginInfo   `json:"loginInfo" doc:"SEMS portal login."`
 	StationInfo StationInfo `json:"stationInfo"`
+	Timezone    string      `json:"timezone" doc:"IANA timezone of the station, e.g. Australia/Sydney. Empty: derived from the weather provider or SEMS."`
 }
 
 type StationInfo struct {
-	StationID string `json:"powerStationId"`
+	StationID string `json:"powerStationId" doc:"SEMS power station ID, from the station URL in the SEMS portal."`
 }
 
 type LoginInfo struct {
-	Account  string `json:"account"`
-	Password string `json:"pwd"`
+	Account  string `json:"account" doc:"SEMS account (email address)."`
+	Password string `json:"pwd" doc:"SEMS password."`
 }
 
 type APIConfig struct {
-	BaseURL     string     `json:"baseURL"`
-	LoginURL    string     `json:"loginURL"`
-	InverterURL string     `json:"inverterURL"`
-	LoginToken  LoginToken `json:"loginToken"`
+	BaseURL        string     `json:"baseURL" doc:"SEMS API base URL."`
+	LoginURL       string     `json:"loginURL" doc:"Login endpoint, relative to baseURL."`
+	InverterURL    string     `json:"inverterURL" doc:"Station detail endpoint, relative to baseURL."`
+	PowerChartURL  string     `json:"powerChartURL" doc:"Day power curve endpoint used by backfill."`
+	EnergyChartURL string     `json:"energyChartURL" doc:"Daily energy chart endpoint used by backfill."`
+	LoginToken     LoginToken `json:"loginToken" doc:"Token sent with the login request."`
 }
 
 type LoginToken struct {
@@ -177,16 +136,65 @@ type LoginToken struct {
 }
 
 type Config struct {
-	APIConfig    APIConfig    `json:"apiConfig"`
-	ClientConfig ClientConfig `json:"clientConfig"`
-	WeatherAPI   WeatherAPI   `json:"weatherAPI"`
+	APIConfig    APIConfig       `json:"apiConfig" doc:"GoodWe SEMS portal API."`
+	ClientConfig ClientConfig    `json:"clientConfig" doc:"SEMS account and station."`
+	WeatherAPI   WeatherAPI      `json:"weatherAPI" doc:"Weather provider."`
+	Database     DatabaseConfig  `json:"database" doc:"MySQL storage."`
+	Collector    CollectorConfig `json:"collector" doc:"Polling and buffering."`
+	Server       ServerConfig    `json:"server" doc:"HTTP API."`
+	Auth         AuthConfig      `json:"auth" doc:"API authentication and rate limiting."`
+	Tariff       TariffConfig    `json:"tariff" doc:"Electricity prices for the savings reports."`
+}
+
+type DatabaseConfig struct {
+	DSN string `json:"dsn" doc:"MySQL DSN, e.g. user:pwd@tcp(localhost:3306)/solar. Empty: no collector, API only."`
+}
+
+type CollectorConfig struct {
+	IntervalSeconds  int    `json:"intervalSeconds" doc:"Seconds between polls."`
+	Duplicates       string `json:"duplicates" doc:"Unchanged readings: skip or upsert."`
+	BufferPath       string `json:"bufferPath" doc:"JSON lines file holding snapshots while MySQL is down. Empty: no buffering."`
+	BufferMaxEntries int    `json:"bufferMaxEntries" doc:"Oldest buffered snapshots are dropped beyond this. 0: unbounded."`
+}
+
+type ServerConfig struct {
+	Listen                 string `json:"listen" doc:"Address to listen on, e.g. 127.0.0.1:22222. The -listen flag takes precedence."`
+	TLSCertFile            string `json:"tlsCertFile" doc:"PEM certificate. Set with tlsKeyFile to serve HTTPS."`
+	TLSKeyFile             string `json:"tlsKeyFile" doc:"PEM private key for tlsCertFile."`
+	TLSSelfSigned          bool   `json:"tlsSelfSigned" doc:"Serve HTTPS with a generated self-signed certificate, written to tlsCertFile/tlsKeyFile when set so it survives restarts."`
+	ReadTimeoutSeconds     int    `json:"readTimeoutSeconds" doc:"Limit for reading a request. 0: none."`
+	WriteTimeoutSeconds    int    `json:"writeTimeoutSeconds" doc:"Limit for writing a response, including exports. 0: none."`
+	ShutdownTimeoutSeconds int    `json:"shutdownTimeoutSeconds" doc:"Time allowed for in-flight requests and the current poll on SIGTERM. 0: 30 seconds."`
 }
 
 type WeatherAPI struct {
-	BaseURL     string `json:"baseURL"`
-	ZipCode     string `json:"zipCode"`
-	CountryCode string `json:"countryCode"`
-	AppID       string `json:"appid"`
+	Provider    string          `json:"provider" doc:"openweathermap, openmeteo, bom or metno."`
+	BaseURL     string          `json:"baseURL" doc:"OpenWeatherMap current weather URL."`
+	ZipCode     string          `json:"zipCode" doc:"OpenWeatherMap location by postcode, with countryCode."`
+	CountryCode string          `json:"countryCode" doc:"ISO 3166 country code for cityName and zipCode."`
+	AppID       string          `json:"appid" doc:"OpenWeatherMap API key."`
+	CityID      string          `json:"cityID" doc:"OpenWeatherMap city ID."`
+	CityName    string          `json:"cityName" doc:"OpenWeatherMap location by city name, with countryCode."`
+	Latitude    float64         `json:"latitude" doc:"Weather location. 0, 0: the station coordinates from SEMS."`
+	Longitude   float64         `json:"longitude"`
+	UserAgent   string          `json:"userAgent" doc:"User-Agent for weather requests; met.no requires contact details."`
+	OpenMeteo   OpenMeteoConfig `json:"openMeteo"`
+	BOM         BOMConfig       `json:"bom" doc:"Bureau of Meteorology observations."`
+	MetNo       MetNoConfig     `json:"metNo"`
+}
+
+type OpenMeteoConfig struct {
+	BaseURL string `json:"baseURL" doc:"Open-Meteo forecast URL."`
+}
+
+type BOMConfig struct {
+	BaseURL   string `json:"baseURL" doc:"BOM observations base URL."`
+	ProductID string `json:"productID" doc:"Observation product, e.g. IDN60901."`
+	StationID string `json:"stationID" doc:"WMO station number, e.g. 94768."`
+}
+
+type MetNoConfig struct {
+	BaseURL string `json:"baseURL" doc:"met.no locationforecast URL."`
 }
 
 type LoginResponse struct {
@@ -430,7 +438,7 @@ type InverterData struct {
 				Istr16                float64 `json:"istr16"`
 			} `json:"d"`
 			ItChangeFlag bool        `json:"it_change_flag"`
-			Tempperature float64     `json:"tempperature"`
+			Tempperature *float64    `json:"tempperature"`
 			CheckCode    string      `json:"check_code"`
 			Next         interface{} `json:"next"`
 			Prev         interface{} `json:"prev"`
@@ -458,7 +466,7 @@ type InverterData struct {
 				Status                  int         `json:"status"`
 				TurnonTime              int64       `json:"turnon_time"`
 				Pac                     float64     `json:"pac"`
-				Tempperature            float64     `json:"tempperature"`
+				Tempperature            *float64    `json:"tempperature"`
 				Vpv1                    float64     `json:"vpv1"`
 				Vpv2                    float64     `json:"vpv2"`
 				Vpv3                    float64     `json:"vpv3"`
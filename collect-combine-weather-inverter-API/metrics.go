@@ -0,0 +1,42 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sync/atomic"
+)
+
+type collectorMetrics struct {
+	readingsStored       atomic.Int64
+	duplicatesSuppressed atomic.Int64
+	collectErrors        atomic.Int64
+	bufferDepth          atomic.Int64
+	bufferReplayed       atomic.Int64
+	bufferDropped        atomic.Int64
+	streamDropped        atomic.Int64
+}
+
+var metrics collectorMetrics
+
+func metricsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	writeCounter(w, "collector_readings_stored_total", "Inverter readings written to the database.", metrics.readingsStored.Load())
+	writeCounter(w, "collector_duplicates_suppressed_total", "Inverter readings skipped or upserted because SEMS had not refreshed them.", metrics.duplicatesSuppressed.Load())
+	writeCounter(w, "collector_errors_total", "Polls that failed before their readings were stored.", metrics.collectErrors.Load())
+	writeGauge(w, "collector_buffer_entries", "Snapshots waiting in the on-disk buffer for the database.", metrics.bufferDepth.Load())
+	writeCounter(w, "collector_buffer_replayed_total", "Buffered snapshots written to the database after it recovered.", metrics.bufferReplayed.Load())
+	writeCounter(w, "collector_buffer_dropped_total", "Buffered snapshots discarded because the buffer was full or unreadable.", metrics.bufferDropped.Load())
+	writeCounter(w, "stream_snapshots_dropped_total", "Snapshots not sent to a /stream client that was too slow to keep up.", metrics.streamDropped.Load())
+}
+
+func writeCounter(w http.ResponseWriter, name string, help string, value int64) {
+	writeMetric(w, "counter", name, help, value)
+}
+
+func writeGauge(w http.ResponseWriter, name string, help string, value int64) {
+	writeMetric(w, "gauge", name, help, value)
+}
+
+func writeMetric(w http.ResponseWriter, kind string, name string, help string, value int64) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n%s %d\n", name, help, name, kind, name, value)
+}
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"flag"
+	"net/http"
+	"reflect"
+	"strings"
+	"time"
+)
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// openAPIBuilder collects component schemas while operations are described.
+type openAPIBuilder struct {
+	schemas map[string]interface{}
+}
+
+func openAPISpec() map[string]interface{} {
+	b := &openAPIBuilder{schemas: make(map[string]interface{})}
+	paths := make(map[string]interface{})
+	for _, op := range apiRoutes(nil, nil, nil) {
+		item, ok := paths[op.Path].(map[string]interface{})
+		if !ok {
+			item = make(map[string]interface{})
+			paths[op.Path] = item
+		}
+		item[strings.ToLower(op.Method)] = b.operation(op)
+	}
+	return map[string]interface{}{
+		"openapi": "3.0.3",
+		"info": map[string]interface{}{
+			"title":       "collect-combine-weather-inverter-API",
+			"version":     "2",
+			"description": "GoodWe SEMS inverter readings combined with weather. All times are RFC 3339 in UTC.",
+		},
+		"paths": paths,
+		"components": map[string]interface{}{
+			"schemas": b.schemas,
+			"securitySchemes": map[string]interface{}{
+				"bearer": map[string]interface{}{"type": "http", "scheme": "bearer"},
+				"apiKey": map[string]interface{}{"type": "apiKey", "in": "header", "name": "X-API-Key"},
+				"basic":  map[string]interface{}{"type": "http", "scheme": "basic"},
+			},
+		},
+	}
+}
+
+func (b *openAPIBuilder) operation(op apiRoute) map[string]interface{} {
+	content := map[string]interface{}{}
+	if op.ContentType != "" {
+		content[op.ContentType] = map[string]interface{}{"schema": map[string]interface{}{"type": "string", "format": "binary"}}
+	} else {
+		content["application/json"] = map[string]interface{}{"schema": b.schema(reflect.TypeOf(op.Response))}
+	}
+	responses := map[string]interface{}{
+		"200": map[string]interface{}{"description": "OK", "content": content},
+	}
+	operation := map[string]interface{}{
+		"summary":     op.Summary,
+		"operationId": orDefault(op.Operation, operationID(op.Path)),
+		"tags":        []string{op.Tag},
+		"responses":   responses,
+	}
+	if len(op.Params) > 0 {
+		var params []interface{}
+		for _, p := range op.Params {
+			schema := map[string]interface{}{"type": "string"}
+			if len(p.Enum) > 0 {
+				schema["enum"] = p.Enum
+			}
+			params = append(params, map[string]interface{}{
+				"name": p.Name, "in": "query", "required": p.Required, "description": p.Description, "schema": schema,
+			})
+		}
+		operation["parameters"] = params
+	}
+	if op.Request != nil {
+		operation["requestBody"] = map[string]interface{}{
+			"required": true,
+			"content": map[string]interface{}{
+				"application/json": map[string]interface{}{"schema": b.schema(reflect.TypeOf(op.Request))},
+			},
+		}
+		responses["400"] = map[string]interface{}{"description": "Malformed request body"}
+	}
+	if op.Scope != "" {
+		// Only enforced when auth is configured; an open API accepts anything.
+		operation["security"] = []interface{}{
+			map[string]interface{}{"bearer": []string{}},
+			map[string]interface{}{"apiKey": []string{}},
+			map[string]interface{}{"basic": []string{}},
+		}
+		responses["401"] = map[string]interface{}{"description": "Missing or unknown credentials"}
+		responses["403"] = map[string]interface{}{"description": "Credentials lack scope " + op.Scope}
+		responses["429"] = map[string]interface{}{"description": "Rate limit exceeded, see Retry-After"}
+	}
+	if op.Tag == "v2" && op.Scope == scopeLive {
+		responses["502"] = map[string]interface{}{
+			"description": "SEMS or the weather provider failed",
+			"content": map[string]interface{}{
+				"application/json": map[string]interface{}{"schema": b.schema(reflect.TypeOf(ErrorV2{}))},
+			},
+		}
+	}
+	if op.Description != "" {
+		operation["description"] = op.Description
+	}
+	if op.Scope != "" {
+		operation["x-scope"] = op.Scope
+	}
+	if op.Deprecated {
+		operation["deprecated"] = true
+	}
+	return operation
+}
+
+// operationID turns /v2/daily-summary into v2DailySummary.
+func operationID(path string) string {
+	var id strings.Builder
+	upper := false
+	for _, r := range strings.TrimPrefix(path, "/") {
+		switch {
+		case r == '/' || r == '-' || r == '.' || r == '{' || r == '}':
+			upper = id.Len() > 0
+		case upper:
+			id.WriteString(strings.ToUpper(string(r)))
+			upper = false
+		default:
+			id.WriteRune(r)
+		}
+	}
+	return id.String()
+}
+
+// schema returns the schema of t, registering named structs as components.
+func (b *openAPIBuilder) schema(t reflect.Type) map[string]interface{} {
+	if t.Kind() == reflect.Ptr {
+		s := b.schema(t.Elem())
+		if _, isRef := s["$ref"]; isRef {
+			return map[string]interface{}{"allOf": []interface{}{s}, "nullable": true}
+		}
+		s["nullable"] = true
+		return s
+	}
+	if t == timeType {
+		return map[string]interface{}{"type": "string", "format": "date-time"}
+	}
+	switch t.Kind() {
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int32:
+		return map[string]interface{}{"type": "integer", "format": "int32"}
+	case reflect.Int64:
+		return map[string]interface{}{"type": "integer", "format": "int64"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number", "format": "double"}
+	case reflect.Slice, reflect.Array:
+		return map[string]interface{}{"type": "array", "items": b.schema(t.Elem())}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": b.schema(t.Elem())}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return b.structSchema(t)
+		}
+		if _, ok := b.schemas[t.Name()]; !ok {
+			b.schemas[t.Name()] = nil // placeholder for recursive types
+			b.schemas[t.Name()] = b.structSchema(t)
+		}
+		return map[string]interface{}{"$ref": "#/components/schemas/" + t.Name()}
+	}
+	return map[string]interface{}{}
+}
+
+func (b *openAPIBuilder) structSchema(t reflect.Type) map[string]interface{} {
+	properties := make(map[string]interface{})
+	var required []string
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		name, options, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		property := b.schema(field.Type)
+		description := field.Tag.Get("doc")
+		if unit := field.Tag.Get("unit"); unit != "" {
+			property["x-unit"] = unit
+			if unit != "1" {
+				description = strings.TrimSpace(description + " Unit: " + unit + ".")
+			}
+		}
+		if description != "" {
+			if _, isRef := property["$ref"]; isRef {
+				property = map[string]interface{}{"allOf": []interface{}{property}, "description": description}
+			} else {
+				property["description"] = description
+			}
+		}
+		properties[name] = property
+		if !strings.Contains(options, "omitempty") {
+			required = append(required, name)
+		}
+	}
+	s := map[string]interface{}{"type": "object", "properties": properties}
+	if len(required) > 0 {
+		s["required"] = required
+	}
+	return s
+}
+
+func openAPIHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, openAPISpec())
+}
+
+func runOpenAPI(args []string) {
+	flag.NewFlagSet("openapi", flag.ExitOnError).Parse(args)
+	printJSON(openAPISpec())
+}
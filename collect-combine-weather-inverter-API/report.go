@@ -0,0 +1,299 @@
+package main
+
+import (
+	"database/sql"
+	"net/http"
+	"sort"
+	"time"
+)
+
+// stationReading is the station-level part of a snapshot: SEMS's income and
+// environmental figures and the lifetime counters used to work out energy
+// flows between polls. Energy is in kWh, power in W.
+type stationReading struct {
+	CollectedAt     time.Time
+	UTCOffset       sql.NullInt64
+	GenerationTotal float64
+	ImportTotal     sql.NullFloat64
+	ExportTotal     sql.NullFloat64
+	MeterPower      sql.NullFloat64
+	IncomeDay       float64
+	IncomeTotal     float64
+	YieldRate       float64
+	Currency        string
+	CO2             float64
+	Trees           float64
+	Coal            float64
+}
+
+// snapshotStationReading takes the import and export counters from the
+// inverters SEMS reports a meter on; without one they are left null.
+func snapshotStationReading(snapshot Snapshot) stationReading {
+	data := snapshot.InverterData.Data
+	reading := stationReading{
+		CollectedAt:     snapshot.CollectedAt,
+		GenerationTotal: data.Kpi.TotalPower,
+		IncomeDay:       data.Kpi.DayIncome,
+		IncomeTotal:     data.Kpi.TotalIncome,
+		YieldRate:       data.Kpi.YieldRate,
+		Currency:        data.Kpi.Currency,
+		CO2:             data.Hjgx.Co2,
+		Trees:           data.Hjgx.Tree,
+		Coal:            data.Hjgx.Coal,
+	}
+	if snapshot.Location != nil {
+		_, offset := snapshot.CollectedAt.In(snapshot.Location).Zone()
+		reading.UTCOffset = sql.NullInt64{Int64: int64(offset), Valid: true}
+	}
+	var energyTotal float64
+	for _, inverter := range data.Inverter {
+		energyTotal += inverter.Etotal
+		if meter := inverter.InvertFull; meter.Hasmeter {
+			reading.ImportTotal.Float64 += meter.TotalBuy
+			reading.ExportTotal.Float64 += meter.TotalSell
+			reading.MeterPower.Float64 += meter.Pmeter
+			reading.ImportTotal.Valid, reading.ExportTotal.Valid, reading.MeterPower.Valid = true, true, true
+		}
+	}
+	if reading.GenerationTotal <= 0 {
+		reading.GenerationTotal = energyTotal
+	}
+	return reading
+}
+
+func (s *Store) stationReadings(stationID string, from time.Time, to time.Time) ([]stationReading, error) {
+	if err := s.ensureSchema(); err != nil {
+		return nil, err
+	}
+	rows, err := s.db.Query(`select collected_at, utc_offset, coalesce(generation_total, 0), import_total, export_total, meter_power,
+		coalesce(income_day, 0), coalesce(income_total, 0), coalesce(yield_rate, 0), coalesce(currency, ''),
+		coalesce(co2, 0), coalesce(trees, 0), coalesce(coal, 0)
+		from station_readings where station_id = ? and collected_at >= ? and collected_at < ?
+		order by collected_at`, stationID, from.UTC(), to.UTC())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var readings []stationReading
+	for rows.Next() {
+		var r stationReading
+		err := rows.Scan(&r.CollectedAt, &r.UTCOffset, &r.GenerationTotal, &r.ImportTotal, &r.ExportTotal, &r.MeterPower,
+			&r.IncomeDay, &r.IncomeTotal, &r.YieldRate, &r.Currency, &r.CO2, &r.Trees, &r.Coal)
+		if err != nil {
+			return nil, err
+		}
+		readings = append(readings, r)
+	}
+	return readings, rows.Err()
+}
+
+// local is when the reading was taken in the station's time, falling back to
+// loc for rows stored before utc_offset was recorded.
+func (r stationReading) local(loc *time.Location) time.Time {
+	if r.UTCOffset.Valid {
+		return r.CollectedAt.In(time.FixedZone("", int(r.UTCOffset.Int64)))
+	}
+	return r.CollectedAt.In(loc)
+}
+
+// energyInterval is the energy that flowed between two polls. Start and End
+// are in the station's local time. Import, Export and SelfConsumption are
+// only known when Metered.
+type energyInterval struct {
+	Start           time.Time
+	End             time.Time
+	Generation      float64
+	Import          float64
+	Export          float64
+	SelfConsumption float64
+	Metered         bool
+}
+
+// Mid is the time the interval's energy is priced at.
+func (i energyInterval) Mid() time.Time {
+	return i.Start.Add(i.End.Sub(i.Start) / 2)
+}
+
+// energyIntervals differences consecutive lifetime counters. Intervals where
+// a counter went backwards, as after an inverter or meter swap, are dropped.
+func energyIntervals(readings []stationReading, loc *time.Location) []energyInterval {
+	var intervals []energyInterval
+	for i := 1; i < len(readings); i++ {
+		prev, cur := readings[i-1], readings[i]
+		interval := energyInterval{
+			Start:      prev.local(loc),
+			End:        cur.local(loc),
+			Generation: cur.GenerationTotal - prev.GenerationTotal,
+		}
+		if interval.Generation < 0 {
+			continue
+		}
+		if prev.ImportTotal.Valid && cur.ImportTotal.Valid && prev.ExportTotal.Valid && cur.ExportTotal.Valid {
+			interval.Import = cur.ImportTotal.Float64 - prev.ImportTotal.Float64
+			interval.Export = cur.ExportTotal.Float64 - prev.ExportTotal.Float64
+			if interval.Import < 0 || interval.Export < 0 {
+				continue
+			}
+			interval.SelfConsumption = interval.Generation - interval.Export
+			if interval.SelfConsumption < 0 {
+				interval.SelfConsumption = 0
+			}
+			interval.Metered = true
+		}
+		intervals = append(intervals, interval)
+	}
+	return intervals
+}
+
+type MonthlyReportV2 struct {
+	StationID string         `json:"station_id"`
+	Month     string         `json:"month" doc:"Local month of the station, YYYY-MM."`
+	Currency  string         `json:"currency" doc:"tariff.currency, or the SEMS currency when unset."`
+	Totals    EnergyReportV2 `json:"totals"`
+	SEMS      SEMSReportV2   `json:"sems" doc:"Figures as SEMS reports them, using the yield rate set in the SEMS portal."`
+	Days      []DayReportV2  `json:"days"`
+}
+
+type DayReportV2 struct {
+	Date       string         `json:"date" doc:"Local day, YYYY-MM-DD."`
+	Energy     EnergyReportV2 `json:"energy"`
+	SEMSIncome float64        `json:"sems_income" doc:"SEMS's income for the day."`
+}
+
+// EnergyReportV2 prices energy with the configured tariff. Import, export
+// and the prices that depend on them stay 0 unless SEMS reports a meter.
+type EnergyReportV2 struct {
+	GenerationKWh      float64 `json:"generation_kwh" unit:"kWh"`
+	ImportKWh          float64 `json:"import_kwh" unit:"kWh" doc:"Bought from the grid."`
+	ExportKWh          float64 `json:"export_kwh" unit:"kWh" doc:"Sold to the grid."`
+	SelfConsumptionKWh float64 `json:"self_consumption_kwh" unit:"kWh" doc:"Generation used on site: generation less export."`
+	Metered            bool    `json:"metered" doc:"Whether import and export were measured for the whole period."`
+	ImportCost         float64 `json:"import_cost" doc:"Import priced at the import rate in force at the time."`
+	ExportCredit       float64 `json:"export_credit" doc:"Export priced at the feed-in rate."`
+	AvoidedImportCost  float64 `json:"avoided_import_cost" doc:"Self-consumption priced at the import rate in force at the time."`
+	Savings            float64 `json:"savings" doc:"export_credit plus avoided_import_cost."`
+}
+
+type SEMSReportV2 struct {
+	Currency     string  `json:"currency"`
+	YieldRate    float64 `json:"yield_rate" doc:"Income per kWh set in the SEMS portal."`
+	IncomeMonth  float64 `json:"income_month" doc:"Sum of SEMS's daily income over the month."`
+	IncomeTotal  float64 `json:"income_total" doc:"Lifetime income at the end of the month."`
+	CO2Avoided   float64 `json:"co2_avoided" doc:"Lifetime CO2 avoided at the end of the month, as SEMS reports it."`
+	TreesPlanted float64 `json:"trees_planted" doc:"Lifetime equivalent trees planted."`
+	CoalSaved    float64 `json:"coal_saved" doc:"Lifetime standard coal saved, as SEMS reports it."`
+}
+
+func (e *EnergyReportV2) add(interval energyInterval, tariff TariffConfig) {
+	e.GenerationKWh += interval.Generation
+	if !interval.Metered {
+		e.Metered = false
+		return
+	}
+	rate, _ := tariff.importRate(interval.Mid())
+	e.ImportKWh += interval.Import
+	e.ExportKWh += interval.Export
+	e.SelfConsumptionKWh += interval.SelfConsumption
+	e.ImportCost += interval.Import * rate
+	e.ExportCredit += interval.Export * tariff.FeedInRate
+	e.AvoidedImportCost += interval.SelfConsumption * rate
+	e.Savings = e.ExportCredit + e.AvoidedImportCost
+}
+
+// reportLocation is the zone used for readings that do not carry their own
+// offset.
+func reportLocation(config Config) *time.Location {
+	if loc, err := time.LoadLocation(config.ClientConfig.Timezone); err == nil && config.ClientConfig.Timezone != "" {
+		return loc
+	}
+	return time.UTC
+}
+
+func monthlyReport(store *Store, config Config, stationID string, month time.Time) (MonthlyReportV2, error) {
+	loc := reportLocation(config)
+	// Offsets are only known per reading, so read a margin either side and
+	// keep what falls in the local month.
+	from := time.Date(month.Year(), month.Month(), 1, 0, 0, 0, 0, time.UTC)
+	to := from.AddDate(0, 1, 0)
+	readings, err := store.stationReadings(stationID, from.Add(-48*time.Hour), to.Add(48*time.Hour))
+	if err != nil {
+		return MonthlyReportV2{}, err
+	}
+	inMonth := func(t time.Time) bool { return t.Year() == month.Year() && t.Month() == month.Month() }
+
+	report := MonthlyReportV2{
+		StationID: stationID,
+		Month:     month.Format("2006-01"),
+		Currency:  config.Tariff.Currency,
+		Totals:    EnergyReportV2{Metered: true},
+		Days:      []DayReportV2{},
+	}
+	days := make(map[string]*DayReportV2)
+	day := func(date string) *DayReportV2 {
+		if days[date] == nil {
+			days[date] = &DayReportV2{Date: date, Energy: EnergyReportV2{Metered: true}}
+		}
+		return days[date]
+	}
+	for _, interval := range energyIntervals(readings, loc) {
+		mid := interval.Mid()
+		if !inMonth(mid) {
+			continue
+		}
+		report.Totals.add(interval, config.Tariff)
+		day(mid.Format(dateLayout)).Energy.add(interval, config.Tariff)
+	}
+	for _, reading := range readings {
+		local := reading.local(loc)
+		if !inMonth(local) {
+			continue
+		}
+		d := day(local.Format(dateLayout))
+		if reading.IncomeDay > d.SEMSIncome {
+			d.SEMSIncome = reading.IncomeDay
+		}
+		report.SEMS = SEMSReportV2{
+			Currency:     reading.Currency,
+			YieldRate:    reading.YieldRate,
+			IncomeTotal:  reading.IncomeTotal,
+			CO2Avoided:   reading.CO2,
+			TreesPlanted: reading.Trees,
+			CoalSaved:    reading.Coal,
+		}
+	}
+	for _, d := range days {
+		report.Days = append(report.Days, *d)
+		report.SEMS.IncomeMonth += d.SEMSIncome
+	}
+	sort.Slice(report.Days, func(i, j int) bool { return report.Days[i].Date < report.Days[j].Date })
+	if report.Currency == "" {
+		report.Currency = report.SEMS.Currency
+	}
+	if len(report.Days) == 0 {
+		report.Totals.Metered = false
+	}
+	return report, nil
+}
+
+func monthlyReportHandler(configs *ConfigSource, store *Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if store == nil {
+			writeJSON(w, http.StatusServiceUnavailable, ErrorV2{Error: "reports need database.dsn to be configured"})
+			return
+		}
+		config := configs.Get()
+		query := r.URL.Query()
+		month, err := time.Parse("2006-01", query.Get("month"))
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, ErrorV2{Error: "month must be YYYY-MM"})
+			return
+		}
+		stationID := orDefault(query.Get("station"), config.ClientConfig.StationInfo.StationID)
+		report, err := monthlyReport(store, config, stationID, month)
+		if err != nil {
+			writeJSON(w, http.StatusInternalServerError, ErrorV2{Error: err.Error()})
+			return
+		}
+		writeJSON(w, http.StatusOK, report)
+	}
+}
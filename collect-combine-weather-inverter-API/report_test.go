@@ -0,0 +1,115 @@
+package main
+
+import (
+	"database/sql"
+	"math"
+	"testing"
+	"time"
+)
+
+func TestEnergyIntervals(t *testing.T) {
+	t0 := time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)
+	at := func(minutes int) time.Time { return t0.Add(time.Duration(minutes) * time.Minute) }
+	metered := func(minutes int, generation, imported, exported float64) stationReading {
+		return stationReading{
+			CollectedAt:     at(minutes),
+			GenerationTotal: generation,
+			ImportTotal:     sql.NullFloat64{Float64: imported, Valid: true},
+			ExportTotal:     sql.NullFloat64{Float64: exported, Valid: true},
+		}
+	}
+	unmetered := func(minutes int, generation float64) stationReading {
+		return stationReading{CollectedAt: at(minutes), GenerationTotal: generation}
+	}
+	tests := []struct {
+		name     string
+		readings []stationReading
+		want     []energyInterval
+	}{
+		{"no readings", nil, nil},
+		{"one reading", []stationReading{metered(0, 100, 50, 20)}, nil},
+		{
+			"metered",
+			[]stationReading{metered(0, 100, 50, 20), metered(5, 101.5, 50.25, 20.5), metered(10, 102, 51, 20.5)},
+			[]energyInterval{
+				{Start: at(0), End: at(5), Generation: 1.5, Import: 0.25, Export: 0.5, SelfConsumption: 1, Metered: true},
+				{Start: at(5), End: at(10), Generation: 0.5, Import: 0.75, Export: 0, SelfConsumption: 0.5, Metered: true},
+			},
+		},
+		{
+			"unmetered",
+			[]stationReading{unmetered(0, 100), unmetered(5, 100.5)},
+			[]energyInterval{{Start: at(0), End: at(5), Generation: 0.5}},
+		},
+		{
+			"meter appears",
+			[]stationReading{unmetered(0, 100), metered(5, 100.5, 50, 20), metered(10, 101, 50, 20.25)},
+			[]energyInterval{
+				{Start: at(0), End: at(5), Generation: 0.5},
+				{Start: at(5), End: at(10), Generation: 0.5, Export: 0.25, SelfConsumption: 0.25, Metered: true},
+			},
+		},
+		{
+			"export counted against stale generation",
+			[]stationReading{metered(0, 100, 50, 20), metered(5, 100, 50, 20.5)},
+			[]energyInterval{{Start: at(0), End: at(5), Export: 0.5, Metered: true}},
+		},
+		{
+			"generation counter reset",
+			[]stationReading{metered(0, 100, 50, 20), metered(5, 101, 50, 20), metered(10, 0.5, 50, 20), metered(15, 1, 50.5, 20)},
+			[]energyInterval{
+				{Start: at(0), End: at(5), Generation: 1, SelfConsumption: 1, Metered: true},
+				{Start: at(10), End: at(15), Generation: 0.5, Import: 0.5, SelfConsumption: 0.5, Metered: true},
+			},
+		},
+		{
+			"import counter reset",
+			[]stationReading{metered(0, 100, 50, 20), metered(5, 101, 0, 20), metered(10, 102, 0.25, 20)},
+			[]energyInterval{{Start: at(5), End: at(10), Generation: 1, Import: 0.25, SelfConsumption: 1, Metered: true}},
+		},
+		{
+			"export counter reset",
+			[]stationReading{metered(0, 100, 50, 20), metered(5, 101, 50, 0), metered(10, 102, 50, 0.5)},
+			[]energyInterval{{Start: at(5), End: at(10), Generation: 1, Export: 0.5, SelfConsumption: 0.5, Metered: true}},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := energyIntervals(test.readings, time.UTC)
+			if len(got) != len(test.want) {
+				t.Fatalf("got %d intervals, want %d: %+v", len(got), len(test.want), got)
+			}
+			for i, want := range test.want {
+				if !sameInterval(got[i], want) {
+					t.Errorf("interval %d = %+v, want %+v", i, got[i], want)
+				}
+			}
+		})
+	}
+}
+
+func TestEnergyIntervalsLocalTime(t *testing.T) {
+	fallback := time.FixedZone("", 10*3600)
+	t0 := time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)
+	readings := []stationReading{
+		{CollectedAt: t0, GenerationTotal: 1},
+		{CollectedAt: t0.Add(time.Hour), UTCOffset: sql.NullInt64{Int64: 11 * 3600, Valid: true}, GenerationTotal: 2},
+	}
+	got := energyIntervals(readings, fallback)
+	if len(got) != 1 {
+		t.Fatalf("got %d intervals, want 1", len(got))
+	}
+	if _, offset := got[0].Start.Zone(); offset != 10*3600 {
+		t.Errorf("start offset = %d, want the fallback zone", offset)
+	}
+	if _, offset := got[0].End.Zone(); offset != 11*3600 {
+		t.Errorf("end offset = %d, want the reading's own offset", offset)
+	}
+}
+
+func sameInterval(a energyInterval, b energyInterval) bool {
+	near := func(x, y float64) bool { return math.Abs(x-y) < 1e-9 }
+	return a.Start.Equal(b.Start) && a.End.Equal(b.End) && near(a.Generation, b.Generation) &&
+		near(a.Import, b.Import) && near(a.Export, b.Export) && near(a.SelfConsumption, b.SelfConsumption) &&
+		a.Metered == b.Metered
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/gorilla/mux"
+)
+
+// apiRoute is one route of the HTTP API. newRouter registers every route from
+// apiRoutes and the OpenAPI spec and Go client are generated from the same
+// list, so neither can miss a route.
+type apiRoute struct {
+	Method string
+	Path   string
+	// Operation names the route in the spec and the Go client. Aliases
+	// leave it empty and are left out of the client.
+	Operation   string
+	Summary     string
+	Description string
+	Tag         string
+	// Scope is required of authenticated clients; empty routes are public.
+	Scope string
+	// Request is a value of the JSON body the route expects, if any.
+	Request interface{}
+	// Response is a value of the JSON type the route returns. Routes that
+	// return something else set ContentType instead.
+	Response    interface{}
+	ContentType string
+	Params      []apiParam
+	Deprecated  bool
+	Handler     http.HandlerFunc
+}
+
+// apiParam is a query parameter.
+type apiParam struct {
+	Name        string
+	Description string
+	Required    bool
+	Enum        []string
+}
+
+func apiRoutes(configs *ConfigSource, store *Store, hub *Hub) []apiRoute {
+	return []apiRoute{
+		{Method: "GET", Path: "/v1/getinverterdata", Operation: "getInverterDataV1", Tag: "v1", Scope: scopeLive, Response: ResponseData{},
+			Summary:     "Live reading of the first inverter with the current weather",
+			Description: "Polls SEMS and the weather provider.",
+			Handler:     getInverterDataHandler(configs)},
+		{Method: "GET", Path: "/v1/getdailysummary", Operation: "getDailySummaryV1", Tag: "v1", Scope: scopeLive, Response: DailySummary{},
+			Summary:     "Today's generation against the clear-sky model",
+			Description: "Polls SEMS.",
+			Handler:     getDailySummaryHandler(configs)},
+		// Unversioned paths are the v1 API, kept for existing clients.
+		{Method: "GET", Path: "/getinverterdata", Tag: "v1", Scope: scopeLive, Response: ResponseData{}, Deprecated: true,
+			Summary: "Unversioned alias of /v1/getinverterdata",
+			Handler: getInverterDataHandler(configs)},
+		{Method: "GET", Path: "/getdailysummary", Tag: "v1", Scope: scopeLive, Response: DailySummary{}, Deprecated: true,
+			Summary: "Unversioned alias of /v1/getdailysummary",
+			Handler: getDailySummaryHandler(configs)},
+		{Method: "GET", Path: "/v2/snapshot", Operation: "getSnapshot", Tag: "v2", Scope: scopeLive, Response: SnapshotV2{},
+			Summary:     "Live readings of every inverter with the current weather",
+			Description: "Polls SEMS and the weather provider.",
+			Handler:     snapshotV2Handler(configs)},
+		{Method: "GET", Path: "/v2/daily-summary", Operation: "getDailySummary", Tag: "v2", Scope: scopeLive, Response: DailySummaryV2{},
+			Summary: "Today's generation against the clear-sky model",
+			Handler: dailySummaryV2Handler(configs)},
+		{Method: "GET", Path: "/v2/power-curve", Operation: "getPowerCurve", Tag: "v2", Scope: scopeRead, Response: PowerCurveV2{},
+			Summary:     "Stored station output averaged over fixed intervals",
+			Description: "Intervals without readings are left out. 503 when no database is configured.",
+			Params: []apiParam{
+				{Name: "station", Description: "Station ID. Default: the configured station."},
+				{Name: "from", Description: "Start of the range, RFC 3339 or YYYY-MM-DD (UTC).", Required: true},
+				{Name: "to", Description: "End of the range, exclusive. Default: now."},
+				{Name: "step", Description: "Interval length in seconds, at least 60. Default: 300."},
+			},
+			Handler: powerCurveHandler(configs, store)},
+		{Method: "GET", Path: "/v2/reports/monthly", Operation: "getMonthlyReport", Tag: "reports", Scope: scopeRead, Response: MonthlyReportV2{},
+			Summary:     "Energy, savings and SEMS income for a month, with a breakdown per day",
+			Description: "Savings are priced with the configured tariff from the meter's import and export counters stored with each poll. 503 when no database is configured.",
+			Params: []apiParam{
+				{Name: "month", Description: "Local month of the station, YYYY-MM.", Required: true},
+				{Name: "station", Description: "Station ID. Default: the configured station."},
+			},
+			Handler: monthlyReportHandler(configs, store)},
+		{Method: "GET", Path: "/v2/reports/bill", Operation: "getBill", Tag: "reports", Scope: scopeRead, Response: BillV2{},
+			Summary:     "What the retailer should bill for a period, with a breakdown per day and time-of-use period",
+			Description: "Prices the metered import and export stored with each poll with the configured tariff, including the daily supply charge and tax. 503 when no database is configured.",
+			Params: []apiParam{
+				{Name: "from", Description: "First local day, YYYY-MM-DD. Default: the start of the current billing period."},
+				{Name: "to", Description: "Last local day, inclusive, YYYY-MM-DD. Required with from."},
+				{Name: "station", Description: "Station ID. Default: the configured station."},
+			},
+			Handler: billHandler(configs, store)},
+		{Method: "GET", Path: "/v2/reports/derating", Operation: "getDeratingEvents", Tag: "reports", Scope: scopeRead, Response: DeratingReportV2{},
+			Summary:     "Stretches where an inverter held its output back as it heated up",
+			Description: "Compares stored output with what the weather allowed for at the inverter's usual performance ratio. A shortfall while the inverter is hot and its temperature rose going into it is reported as a derating event. 503 when no database is configured.",
+			Params: []apiParam{
+				{Name: "from", Description: "Start of the range, RFC 3339 or YYYY-MM-DD (UTC). Default: a week before to."},
+				{Name: "to", Description: "End of the range, exclusive. Default: now."},
+				{Name: "station", Description: "Station ID. Default: the configured station."},
+				{Name: "min_temperature", Description: "Inverter temperature in °C from which a shortfall counts as derating. Default: 50."},
+				{Name: "shortfall_percent", Description: "How far below the expected output a reading must be. Default: 15."},
+			},
+			Handler: deratingHandler(configs, store)},
+		{Method: "GET", Path: "/v2/reports/clipping", Operation: "getClipping", Tag: "reports", Scope: scopeRead, Response: ClippingReportV2{},
+			Summary:     "Energy lost each sunny day to output held at the inverter rating or export limit",
+			Description: "Finds stretches of stored readings where output flatlines at the lower of the inverter's capacity and its SEMS export power limit, and estimates what the weather allowed for above it. 503 when no database is configured.",
+			Params: []apiParam{
+				{Name: "from", Description: "Start of the range, RFC 3339 or YYYY-MM-DD (UTC). Default: a week before to."},
+				{Name: "to", Description: "End of the range, exclusive. Default: now."},
+				{Name: "station", Description: "Station ID. Default: the configured station."},
+				{Name: "max_cloud_percent", Description: "Mean daylight cloud cover up to which a day counts as sunny. Default: 30."},
+			},
+			Handler: clippingHandler(configs, store)},
+		{Method: "GET", Path: "/stream", Operation: "streamSnapshots", Tag: "v2", Scope: scopeRead, ContentType: "text/event-stream",
+			Summary:     "Each new snapshot as it is collected, over server-sent events or a WebSocket",
+			Description: "Sends the latest snapshot on connect, then one event per poll that brought new readings, in the /v2/snapshot shape. Upgrades to a WebSocket with one JSON message per snapshot when asked to.",
+			Params: []apiParam{
+				{Name: "station", Description: "Comma-separated station IDs. Default: every station."},
+				{Name: "fields", Description: "Comma-separated fields to send: top-level keys of /v2/snapshot, inverter keys as inverters.<key> and weather keys as weather.<key>, e.g. inverters.capacity_kw for each inverter's capacity and capacity_kw for the station's. An inverter key without the prefix works when no top-level key has its name. station_id, collected_at and serial_number are always sent. Default: all."},
+			},
+			Handler: streamHandler(hub)},
+		{Method: "GET", Path: "/export", Operation: "exportReadings", Tag: "history", Scope: scopeRead, ContentType: "text/csv",
+			Summary:     "Stored readings as CSV or Parquet",
+			Description: "One row per inverter reading with the weather observed alongside it, streamed from the database. 503 when no database is configured.",
+			Params: []apiParam{
+				{Name: "station", Description: "Station ID. Default: the configured station."},
+				{Name: "from", Description: "Start of the range, RFC 3339 or YYYY-MM-DD (UTC). Default: a week before to. At most five years before to."},
+				{Name: "to", Description: "End of the range, exclusive. Default: now."},
+				{Name: "format", Description: "Default: csv.", Enum: []string{"csv", "parquet"}},
+			},
+			Handler: exportHandler(configs, store)},
+		{Method: "POST", Path: "/search", Operation: "grafanaSearch", Tag: "grafana", Scope: scopeRead, Request: GrafanaSearchRequest{}, Response: []string{},
+			Summary:     "Metrics for the Grafana SimpleJSON datasource",
+			Description: "Station metrics, then inverter metrics as <metric>:<serial number> for the inverters seen in the last year.",
+			Handler:     grafanaSearchHandler(configs, store)},
+		{Method: "POST", Path: "/query", Operation: "grafanaQuery", Tag: "grafana", Scope: scopeRead, Request: GrafanaQueryRequest{}, Response: []GrafanaQueryResult{},
+			Summary:     "Stored readings as Grafana time series or tables",
+			Description: "Each target is averaged over intervalMs. Station power and energy add the inverters up; ratios and weather are averaged. 503 when no database is configured.",
+			Handler:     grafanaQueryHandler(configs, store)},
+		{Method: "POST", Path: "/annotations", Operation: "grafanaAnnotations", Tag: "grafana", Scope: scopeRead, Request: GrafanaAnnotationRequest{}, Response: []GrafanaAnnotation{},
+			Summary:     "Inverter faults and weather changes as Grafana annotations",
+			Description: "annotation.query selects faults, weather or, when empty, both. 503 when no database is configured.",
+			Handler:     grafanaAnnotationsHandler(configs, store)},
+		{Method: "GET", Path: "/inventory", Operation: "getInventory", Tag: "history", Scope: scopeRead, Response: InventoryV2{},
+			Summary:     "Equipment per station with its model, firmware and datalogger, and how they changed",
+			Description: "Recorded from every poll, so changes such as firmware upgrades or datalogger swaps show up with the time they were first seen. 503 when no database is configured.",
+			Params: []apiParam{
+				{Name: "station", Description: "Station ID. Default: every station."},
+			},
+			Handler: inventoryHandler(store)},
+		{Method: "GET", Path: "/metrics", Operation: "getMetrics", Tag: "operations", Scope: scopeRead, ContentType: "text/plain",
+			Summary: "Collector metrics in the Prometheus text format",
+			Handler: metricsHandler},
+		{Method: "GET", Path: "/openapi.json", Operation: "getOpenAPI", Tag: "operations", Response: map[string]interface{}{},
+			Summary: "This OpenAPI document",
+			Handler: openAPIHandler},
+		{Method: "GET", Path: "/v2/openapi.json", Tag: "operations", Response: map[string]interface{}{}, Deprecated: true,
+			Summary: "Alias of /openapi.json",
+			Handler: openAPIHandler},
+	}
+}
+
+func newRouter(configs *ConfigSource, store *Store, hub *Hub) *mux.Router {
+	r := mux.NewRouter()
+	auth := newAuthenticator(configs)
+	for _, route := range apiRoutes(configs, store, hub) {
+		handler := route.Handler
+		if route.Scope != "" {
+			handler = auth.require(route.Scope, handler)
+		}
+		r.HandleFunc(route.Path, handler).Methods(route.Method)
+	}
+	// The dashboard takes every other path; its files are public.
+	r.PathPrefix("/").Handler(dashboardHandler()).Methods("GET")
+	return r
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+func postSEMS(url string, token interface{}, postData interface{}, v interface{}) error {
+	client := &http.Client{Timeout: 30 * time.Second}
+	b, err := json.Marshal(postData)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("POST", url, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	tokenByte, err := json.Marshal(token)
+	if err != nil {
+		return err
+	}
+	req.Header.Add("Content-Type", "application/json")
+	req.Header.Add("Accept", "application/json")
+	req.Header.Add("token", string(tokenByte))
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("POST %s: %s", url, resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+func runLoginRequest(config Config) (LoginResponse, error) {
+	var loginResponse LoginResponse
+	err := postSEMS(config.APIConfig.BaseURL+config.APIConfig.LoginURL, config.APIConfig.LoginToken, config.ClientConfig.LoginInfo, &loginResponse)
+	if err != nil {
+		return loginResponse, fmt.Errorf("SEMS login: %v", err)
+	}
+	if loginResponse.HasError {
+		return loginResponse, fmt.Errorf("SEMS login: %s", loginResponse.Msg)
+	}
+	return loginResponse, nil
+}
+
+func getInverterData(config Config, loginResponse LoginResponse) (InverterData, error) {
+	var inverterData InverterData
+	err := postSEMS(config.APIConfig.BaseURL+config.APIConfig.InverterURL, loginResponse.Data, config.ClientConfig.StationInfo, &inverterData)
+	if err != nil {
+		return inverterData, fmt.Errorf("SEMS inverter data: %v", err)
+	}
+	if inverterData.HasError {
+		return inverterData, fmt.Errorf("SEMS inverter data: %s", inverterData.Msg)
+	}
+	return inverterData, nil
+}
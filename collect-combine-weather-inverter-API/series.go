@@ -0,0 +1,104 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+type seriesPoint struct {
+	Time  time.Time
+	Value float64
+}
+
+// inverterSeries averages a column of inverter_readings over step-long
+// intervals. Without an inverter the inverters are then added up, or
+// averaged when sum is false. Station-level rows from backfill are only used
+// for intervals without live readings, so the two are not counted twice.
+// column must come from code, never from a request.
+func (s *Store) inverterSeries(stationID string, column string, inverterSN string, sum bool, from time.Time, to time.Time, step time.Duration) ([]seriesPoint, error) {
+	if err := s.ensureSchema(); err != nil {
+		return nil, err
+	}
+	seconds := int64(step / time.Second)
+	query := fmt.Sprintf(`select floor(unix_timestamp(read_time) / ?) * ?, inverter_sn, avg(%[1]s)
+		from inverter_readings
+		where station_id = ? and read_time >= ? and read_time < ? and %[1]s is not null`, column)
+	args := []interface{}{seconds, seconds, stationID, from.UTC(), to.UTC()}
+	if inverterSN != "" {
+		query += " and inverter_sn = ?"
+		args = append(args, inverterSN)
+	}
+	rows, err := s.db.Query(query+" group by 1, inverter_sn", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	totals := make(map[int64]float64)
+	counts := make(map[int64]int)
+	station := make(map[int64]float64)
+	for rows.Next() {
+		var bucket int64
+		var sn string
+		var value float64
+		if err := rows.Scan(&bucket, &sn, &value); err != nil {
+			return nil, err
+		}
+		if sn == "" {
+			station[bucket] = value
+		} else {
+			totals[bucket] += value
+			counts[bucket]++
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	for bucket, value := range station {
+		if _, ok := totals[bucket]; !ok {
+			totals[bucket] = value
+			counts[bucket] = 1
+		}
+	}
+	points := make([]seriesPoint, 0, len(totals))
+	for bucket, value := range totals {
+		if !sum {
+			value /= float64(counts[bucket])
+		}
+		points = append(points, seriesPoint{Time: time.Unix(bucket, 0).UTC(), Value: value})
+	}
+	sortSeries(points)
+	return points, nil
+}
+
+// weatherSeries averages a column of weather_readings over step-long
+// intervals. column must come from code, never from a request.
+func (s *Store) weatherSeries(stationID string, column string, from time.Time, to time.Time, step time.Duration) ([]seriesPoint, error) {
+	if err := s.ensureSchema(); err != nil {
+		return nil, err
+	}
+	seconds := int64(step / time.Second)
+	rows, err := s.db.Query(fmt.Sprintf(`select floor(unix_timestamp(observed_at) / ?) * ?, avg(%[1]s)
+		from weather_readings
+		where station_id = ? and observed_at >= ? and observed_at < ? and %[1]s is not null
+		group by 1`, column), seconds, seconds, stationID, from.UTC(), to.UTC())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var points []seriesPoint
+	for rows.Next() {
+		var bucket int64
+		var value float64
+		if err := rows.Scan(&bucket, &value); err != nil {
+			return nil, err
+		}
+		points = append(points, seriesPoint{Time: time.Unix(bucket, 0).UTC(), Value: value})
+	}
+	sortSeries(points)
+	return points, rows.Err()
+}
+
+func sortSeries(points []seriesPoint) {
+	sort.Slice(points, func(i, j int) bool { return points[i].Time.Before(points[j].Time) })
+}
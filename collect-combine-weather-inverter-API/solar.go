@@ -0,0 +1,184 @@
+package main
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+const degToRad = math.Pi / 180
+
+// PVPerformance compares actual inverter output against the theoretical output
+// of the array. Output is in W, yield in kWh/kWp over the interval since the
+// inverter's previous reading.
+type PVPerformance struct {
+	SolarZenith      float64
+	ClearSkyGHI      float64
+	ClearSkyOutput   float64
+	PerformanceRatio float64
+	SpecificYield    float64
+}
+
+type DailySummary struct {
+	Date             string  `json:"date"`
+	Capacity         float64 `json:"capacity" unit:"kW"`
+	EnergyDay        float64 `json:"dayoutput" unit:"kWh"`
+	ClearSkyEnergy   float64 `json:"clearskyoutput" unit:"kWh"`
+	PerformanceRatio float64 `json:"performanceratio" unit:"1"`
+	SpecificYield    float64 `json:"specificyield" unit:"kWh/kWp" doc:"Energy so far today per kW of capacity."`
+	Sunrise          int     `json:"sunrise" unit:"s" doc:"Unix time."`
+	Sunset           int     `json:"sunset" unit:"s" doc:"Unix time."`
+}
+
+// solarGeometry returns the equation of time (minutes) and solar declination
+// (radians) for t using the NOAA fractional-year approximation.
+func solarGeometry(t time.Time) (float64, float64) {
+	t = t.UTC()
+	hour := float64(t.Hour()) + float64(t.Minute())/60 + float64(t.Second())/3600
+	gamma := 2 * math.Pi / 365 * (float64(t.YearDay()-1) + (hour-12)/24)
+	eqTime := 229.18 * (0.000075 + 0.001868*math.Cos(gamma) - 0.032077*math.Sin(gamma) -
+		0.014615*math.Cos(2*gamma) - 0.040849*math.Sin(2*gamma))
+	decl := 0.006918 - 0.399912*math.Cos(gamma) + 0.070257*math.Sin(gamma) -
+		0.006758*math.Cos(2*gamma) + 0.000907*math.Sin(2*gamma) -
+		0.002697*math.Cos(3*gamma) + 0.00148*math.Sin(3*gamma)
+	return eqTime, decl
+}
+
+// solarZenith returns the solar zenith angle in degrees.
+func solarZenith(t time.Time, latitude float64, longitude float64) float64 {
+	t = t.UTC()
+	eqTime, decl := solarGeometry(t)
+	trueSolarMinutes := float64(t.Hour()*60+t.Minute()) + float64(t.Second())/60 + eqTime + 4*longitude
+	hourAngle := (trueSolarMinutes/4 - 180) * degToRad
+	lat := latitude * degToRad
+	cosZenith := math.Sin(lat)*math.Sin(decl) + math.Cos(lat)*math.Cos(decl)*math.Cos(hourAngle)
+	return math.Acos(math.Max(-1, math.Min(1, cosZenith))) / degToRad
+}
+
+// clearSkyGHI is the Haurwitz clear-sky global horizontal irradiance in W/m².
+func clearSkyGHI(zenith float64) float64 {
+	cosZenith := math.Cos(zenith * degToRad)
+	if cosZenith <= 0 {
+		return 0
+	}
+	return 1098 * cosZenith * math.Exp(-0.057/cosZenith)
+}
+
+// sunriseSunset returns Unix times for the sunrise and sunset on the calendar
+// date of t in its own location, or zeros during polar day or night.
+func sunriseSunset(t time.Time, latitude float64, longitude float64) (int, int) {
+	noon := time.Date(t.Year(), t.Month(), t.Day(), 12, 0, 0, 0, time.UTC)
+	eqTime, decl := solarGeometry(noon)
+	lat := latitude * degToRad
+	cosHourAngle := math.Cos(90.833*degToRad)/(math.Cos(lat)*math.Cos(decl)) - math.Tan(lat)*math.Tan(decl)
+	if cosHourAngle < -1 || cosHourAngle > 1 {
+		return 0, 0
+	}
+	hourAngle := math.Acos(cosHourAngle) / degToRad
+	midnight := noon.Add(-12 * time.Hour).Unix()
+	sunrise := midnight + int64((720-4*(longitude+hourAngle)-eqTime)*60)
+	sunset := midnight + int64((720-4*(longitude-hourAngle)-eqTime)*60)
+	return int(sunrise), int(sunset)
+}
+
+// energyMark is an inverter's energy so far today as of a SEMS reading.
+type energyMark struct {
+	readTime  time.Time
+	energyDay float64
+}
+
+// intervalTracker keeps the last two distinct readings of each inverter, so
+// the energy of the interval ending at a reading is the same however often
+// SEMS is polled and by whom.
+type intervalTracker struct {
+	mu    sync.Mutex
+	marks map[string][2]energyMark
+}
+
+var intervals = intervalTracker{marks: make(map[string][2]energyMark)}
+
+// energy returns the kWh produced between the inverter's previous reading
+// and the one at readTime, or false for its first reading or one older than
+// the latest. Since energyDay restarts each local day, a drop means the
+// interval began before midnight and energyDay is all of it that is known.
+func (t *intervalTracker) energy(key string, readTime time.Time, energyDay float64) (float64, bool) {
+	if readTime.IsZero() {
+		return 0, false
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	marks := t.marks[key]
+	var previous energyMark
+	switch {
+	case marks[1].readTime.Equal(readTime):
+		previous = marks[0]
+	case readTime.After(marks[1].readTime):
+		previous = marks[1]
+		t.marks[key] = [2]energyMark{marks[1], {readTime: readTime, energyDay: energyDay}}
+	default:
+		return 0, false
+	}
+	if previous.readTime.IsZero() {
+		return 0, false
+	}
+	if energyDay < previous.energyDay {
+		return energyDay, true
+	}
+	return energyDay - previous.energyDay, true
+}
+
+// pvPerformance uses measured irradiance for the performance ratio when the
+// weather provider supplies it, otherwise the clear-sky estimate.
+// intervalEnergy is the kWh produced since the inverter's previous reading.
+func pvPerformance(t time.Time, latitude float64, longitude float64, capacityKW float64, pac float64, intervalEnergy float64, measuredGHI float64) PVPerformance {
+	zenith := solarZenith(t, latitude, longitude)
+	perf := PVPerformance{
+		SolarZenith:    zenith,
+		ClearSkyGHI:    clearSkyGHI(zenith),
+		ClearSkyOutput: capacityKW * clearSkyGHI(zenith),
+	}
+	if capacityKW <= 0 {
+		return perf
+	}
+	perf.SpecificYield = intervalEnergy / capacityKW
+	irradiance := measuredGHI
+	if irradiance <= 0 {
+		irradiance = perf.ClearSkyGHI
+	}
+	if irradiance > 0 {
+		perf.PerformanceRatio = pac / (capacityKW * irradiance)
+	}
+	return perf
+}
+
+// clearSkyDailyEnergy integrates clear-sky output in kWh over the local day
+// starting at dayStart.
+func clearSkyDailyEnergy(dayStart time.Time, latitude float64, longitude float64, capacityKW float64) float64 {
+	const step = 5 * time.Minute
+	var wattHours float64
+	for t := dayStart; t.Before(dayStart.Add(24 * time.Hour)); t = t.Add(step) {
+		wattHours += capacityKW * clearSkyGHI(solarZenith(t.Add(step/2), latitude, longitude)) * step.Hours()
+	}
+	return wattHours / 1000
+}
+
+func dailySummary(now time.Time, loc *time.Location, latitude float64, longitude float64, capacityKW float64, energyDay float64) DailySummary {
+	local := now.In(loc)
+	dayStart := time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, loc)
+	sunrise, sunset := sunriseSunset(dayStart, latitude, longitude)
+	summary := DailySummary{
+		Date:           dayStart.Format("2006-01-02"),
+		Capacity:       capacityKW,
+		EnergyDay:      energyDay,
+		ClearSkyEnergy: clearSkyDailyEnergy(dayStart, latitude, longitude, capacityKW),
+		Sunrise:        sunrise,
+		Sunset:         sunset,
+	}
+	if capacityKW > 0 {
+		summary.SpecificYield = energyDay / capacityKW
+	}
+	if summary.ClearSkyEnergy > 0 {
+		summary.PerformanceRatio = energyDay / summary.ClearSkyEnergy
+	}
+	return summary
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestIntervalTrackerEnergy(t *testing.T) {
+	t0 := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	at := func(minutes int) time.Time { return t0.Add(time.Duration(minutes) * time.Minute) }
+	tracker := intervalTracker{marks: make(map[string][2]energyMark)}
+	steps := []struct {
+		name      string
+		readTime  time.Time
+		energyDay float64
+		want      float64
+		ok        bool
+	}{
+		{"first reading", at(0), 10, 0, false},
+		{"next reading", at(5), 10.5, 0.5, true},
+		{"same reading polled again", at(5), 10.5, 0.5, true},
+		{"later reading", at(10), 11.25, 0.75, true},
+		{"older reading", at(5), 10.5, 0, false},
+		{"new day", at(15), 0.25, 0.25, true},
+		{"no read time", time.Time{}, 1, 0, false},
+	}
+	for _, step := range steps {
+		got, ok := tracker.energy("station/INV1", step.readTime, step.energyDay)
+		if got != step.want || ok != step.ok {
+			t.Errorf("%s: energy = %v, %v, want %v, %v", step.name, got, ok, step.want, step.ok)
+		}
+	}
+	if _, ok := tracker.energy("station/INV2", at(5), 3); ok {
+		t.Errorf("another inverter's first reading has an interval")
+	}
+}
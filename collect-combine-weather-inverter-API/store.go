@@ -0,0 +1,362 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+// migrations are applied in order and recorded in schema_migrations; append
+// new statements, never edit released ones.
+var migrations = []string{
+	`create table if not exists weather_readings (
+		id bigint auto_increment primary key,
+		station_id varchar(64) not null,
+		provider varchar(32) not null,
+		observed_at datetime not null,
+		temperature double,
+		humidity double,
+		pressure double,
+		wind_speed double,
+		wind_deg double,
+		cloud_percent int,
+		weather varchar(64),
+		weather_description varchar(255),
+		sunrise datetime null,
+		sunset datetime null,
+		ghi double,
+		dni double,
+		dhi double,
+		unique key uq_weather_observed (station_id, provider, observed_at)
+	)`,
+	`create table if not exists inverter_readings (
+		id bigint auto_increment primary key,
+		station_id varchar(64) not null,
+		inverter_sn varchar(64) not null,
+		inverter_name varchar(128),
+		capacity double,
+		read_time datetime not null,
+		boot_time datetime null,
+		collected_at datetime not null,
+		output_power double,
+		energy_day double,
+		energy_month double,
+		energy_total double,
+		clear_sky_output double,
+		performance_ratio double,
+		specific_yield double,
+		weather_id bigint null,
+		unique key uq_inverter_read (station_id, inverter_sn, read_time),
+		key ix_weather (weather_id)
+	)`,
+	`alter table inverter_readings
+		add column source varchar(16) not null default 'live',
+		add key ix_station_read (station_id, read_time)`,
+	`create table if not exists daily_energy (
+		station_id varchar(64) not null,
+		day date not null,
+		energy double,
+		source varchar(16) not null,
+		primary key (station_id, day)
+	)`,
+	`alter table inverter_readings
+		add column status int,
+		add column work_hours double,
+		add column vpv1 double, add column vpv2 double, add column vpv3 double, add column vpv4 double,
+		add column ipv1 double, add column ipv2 double, add column ipv3 double, add column ipv4 double,
+		add column vac1 double, add column vac2 double, add column vac3 double,
+		add column iac1 double, add column iac2 double, add column iac3 double,
+		add column fac1 double, add column fac2 double, add column fac3 double`,
+	`create table if not exists import_progress (
+		name varchar(64) primary key,
+		position varchar(32) not null,
+		rows_imported bigint not null,
+		updated_at datetime not null
+	)`,
+	`create table if not exists station_readings (
+		station_id varchar(64) not null,
+		collected_at datetime not null,
+		utc_offset int null,
+		generation_total double,
+		import_total double null,
+		export_total double null,
+		meter_power double null,
+		income_day double,
+		income_total double,
+		yield_rate double,
+		currency varchar(8),
+		co2 double,
+		trees double,
+		coal double,
+		primary key (station_id, collected_at)
+	)`,
+	`create table if not exists equipment_inventory (
+		id bigint auto_increment primary key,
+		station_id varchar(64) not null,
+		equipment_sn varchar(64) not null,
+		field varchar(32) not null,
+		value varchar(255) not null,
+		first_seen datetime not null,
+		last_seen datetime not null,
+		replaced_at datetime null,
+		key ix_equipment_field (station_id, equipment_sn, field, first_seen)
+	)`,
+	`alter table inverter_readings add column temperature double null`,
+}
+
+type Store struct {
+	db       *sql.DB
+	mu       sync.Mutex
+	migrated bool
+}
+
+// openStore forces parseTime and a UTC session so DATETIME columns always
+// hold UTC regardless of the server's time_zone.
+func openStore(dsn string) (*Store, error) {
+	cfg, err := mysql.ParseDSN(dsn)
+	if err != nil {
+		return nil, fmt.Errorf("database.dsn: %v", err)
+	}
+	cfg.ParseTime = true
+	cfg.Loc = time.UTC
+	if cfg.Params == nil {
+		cfg.Params = map[string]string{}
+	}
+	cfg.Params["time_zone"] = "'+00:00'"
+	db, err := sql.Open("mysql", cfg.FormatDSN())
+	if err != nil {
+		return nil, err
+	}
+	return &Store{db: db}, nil
+}
+
+func (s *Store) Close() error {
+	return s.db.Close()
+}
+
+// ensureSchema migrates on first use so the collector can start, and buffer,
+// while the database is still unreachable.
+func (s *Store) ensureSchema() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.migrated {
+		return nil
+	}
+	if err := s.migrate(); err != nil {
+		return err
+	}
+	s.migrated = true
+	return nil
+}
+
+func (s *Store) migrate() error {
+	_, err := s.db.Exec(`create table if not exists schema_migrations (
+		version int primary key,
+		applied_at datetime not null
+	)`)
+	if err != nil {
+		return fmt.Errorf("migrate: %v", err)
+	}
+	var current int
+	if err := s.db.QueryRow(`select coalesce(max(version), 0) from schema_migrations`).Scan(&current); err != nil {
+		return fmt.Errorf("migrate: %v", err)
+	}
+	for i := current; i < len(migrations); i++ {
+		if _, err := s.db.Exec(migrations[i]); err != nil {
+			return fmt.Errorf("migrate to version %d: %v", i+1, err)
+		}
+		if _, err := s.db.Exec(`insert into schema_migrations (version, applied_at) values (?, ?)`, i+1, time.Now().UTC()); err != nil {
+			return fmt.Errorf("migrate to version %d: %v", i+1, err)
+		}
+	}
+	return nil
+}
+
+// storedSnapshot is the part of a snapshot written to the database, without
+// the raw SEMS response, so it stays small while buffered.
+type storedSnapshot struct {
+	StationID   string
+	CollectedAt time.Time
+	Weather     Weather
+	Readings    []Reading
+	Station     stationReading
+	Inventory   map[string]map[string]string
+}
+
+func newStoredSnapshot(snapshot Snapshot) storedSnapshot {
+	return storedSnapshot{
+		StationID:   snapshot.StationID,
+		CollectedAt: snapshot.CollectedAt,
+		Weather:     snapshot.Weather,
+		Readings:    snapshot.Readings,
+		Station:     snapshotStationReading(snapshot),
+		Inventory:   snapshotInventory(snapshot),
+	}
+}
+
+// saveSnapshot writes the station reading and equipment inventory of a
+// snapshot and, when it has inverter readings, those and the weather. It
+// returns how many readings already existed under their unique key.
+func (s *Store) saveSnapshot(snapshot storedSnapshot, upsert bool) (int, error) {
+	if err := s.ensureSchema(); err != nil {
+		return 0, err
+	}
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	station := snapshot.Station
+	_, err = tx.Exec(`insert ignore into station_readings (station_id, collected_at, utc_offset, generation_total,
+		import_total, export_total, meter_power, income_day, income_total, yield_rate, currency, co2, trees, coal)
+		values (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		snapshot.StationID, snapshot.CollectedAt, station.UTCOffset, station.GenerationTotal,
+		station.ImportTotal, station.ExportTotal, station.MeterPower, station.IncomeDay, station.IncomeTotal,
+		station.YieldRate, station.Currency, station.CO2, station.Trees, station.Coal)
+	if err != nil {
+		return 0, fmt.Errorf("insert station reading: %v", err)
+	}
+	if err := saveInventory(tx, snapshot); err != nil {
+		return 0, err
+	}
+	if len(snapshot.Readings) == 0 {
+		return 0, tx.Commit()
+	}
+
+	weather := snapshot.Weather
+	res, err := tx.Exec(`insert into weather_readings (station_id, provider, observed_at, temperature, humidity, pressure,
+		wind_speed, wind_deg, cloud_percent, weather, weather_description, sunrise, sunset, ghi, dni, dhi)
+		values (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		on duplicate key update id = last_insert_id(id)`,
+		snapshot.StationID, weather.Provider, unixToUTC(int(weather.ObservedAt)), weather.Temperature, weather.Humidity, weather.Pressure,
+		weather.WindSpeed, weather.WindDeg, weather.CloudPercent, weather.Type, weather.Description,
+		nullTime(unixToUTC(weather.Sunrise)), nullTime(unixToUTC(weather.Sunset)), weather.GHI, weather.DNI, weather.DHI)
+	if err != nil {
+		return 0, fmt.Errorf("insert weather: %v", err)
+	}
+	weatherID, err := res.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+
+	verb := "insert ignore"
+	onDuplicate := ""
+	if upsert {
+		verb = "insert"
+		onDuplicate = ` on duplicate key update inverter_name = values(inverter_name), capacity = values(capacity),
+			boot_time = values(boot_time), collected_at = values(collected_at), output_power = values(output_power),
+			energy_day = values(energy_day), energy_month = values(energy_month), energy_total = values(energy_total),
+			clear_sky_output = values(clear_sky_output), performance_ratio = values(performance_ratio),
+			specific_yield = values(specific_yield), weather_id = values(weather_id), status = values(status),
+			work_hours = values(work_hours), vpv1 = values(vpv1), vpv2 = values(vpv2), vpv3 = values(vpv3), vpv4 = values(vpv4),
+			ipv1 = values(ipv1), ipv2 = values(ipv2), ipv3 = values(ipv3), ipv4 = values(ipv4),
+			vac1 = values(vac1), vac2 = values(vac2), vac3 = values(vac3), iac1 = values(iac1), iac2 = values(iac2), iac3 = values(iac3),
+			fac1 = values(fac1), fac2 = values(fac2), fac3 = values(fac3), temperature = values(temperature)`
+	}
+	stmt, err := tx.Prepare(verb + ` into inverter_readings (station_id, inverter_sn, inverter_name, capacity, read_time, boot_time,
+		collected_at, output_power, energy_day, energy_month, energy_total, clear_sky_output, performance_ratio, specific_yield, weather_id,
+		status, work_hours, vpv1, vpv2, vpv3, vpv4, ipv1, ipv2, ipv3, ipv4, vac1, vac2, vac3, iac1, iac2, iac3, fac1, fac2, fac3,
+		temperature)
+		values (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)` + onDuplicate)
+	if err != nil {
+		return 0, err
+	}
+	defer stmt.Close()
+	duplicates := 0
+	for _, reading := range snapshot.Readings {
+		args := []interface{}{snapshot.StationID, reading.InverterSN, reading.InverterName, reading.Capacity, reading.ReadTime,
+			nullTime(reading.BootTime), snapshot.CollectedAt, reading.OutputPower, reading.EnergyDay, reading.EnergyMonth,
+			reading.EnergyTotal, reading.Performance.ClearSkyOutput, reading.Performance.PerformanceRatio,
+			reading.Performance.SpecificYield, weatherID, reading.Status, reading.WorkHours}
+		for _, values := range [][]float64{reading.Vpv[:], reading.Ipv[:], reading.Vac[:], reading.Iac[:], reading.Fac[:]} {
+			for _, v := range values {
+				args = append(args, v)
+			}
+		}
+		args = append(args, optionalFloat(reading.Temperature))
+		res, err := stmt.Exec(args...)
+		if err != nil {
+			return 0, fmt.Errorf("insert reading for %s: %v", reading.InverterSN, err)
+		}
+		// MySQL reports 1 for a new row, 2 for an updated one and 0 when ignored or unchanged.
+		if affected, err := res.RowsAffected(); err == nil && affected != 1 {
+			duplicates++
+		}
+	}
+	return duplicates, tx.Commit()
+}
+
+func nullTime(t time.Time) sql.NullTime {
+	return sql.NullTime{Time: t, Valid: !t.IsZero()}
+}
+
+func optionalFloat(v *float64) sql.NullFloat64 {
+	if v == nil {
+		return sql.NullFloat64{}
+	}
+	return sql.NullFloat64{Float64: *v, Valid: true}
+}
+
+// saveBackfilledPower stores station-level power curve points as readings
+// with an empty inverter_sn. Existing rows are left untouched.
+func (s *Store) saveBackfilledPower(stationID string, points []PowerPoint) (int, error) {
+	if len(points) == 0 {
+		return 0, nil
+	}
+	if err := s.ensureSchema(); err != nil {
+		return 0, err
+	}
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+	stmt, err := tx.Prepare(`insert ignore into inverter_readings (station_id, inverter_sn, read_time, collected_at, output_power, source)
+		values (?, '', ?, ?, ?, 'backfill')`)
+	if err != nil {
+		return 0, err
+	}
+	defer stmt.Close()
+	now := time.Now().UTC()
+	inserted := 0
+	for _, point := range points {
+		res, err := stmt.Exec(stationID, point.Time, now, point.Power)
+		if err != nil {
+			return 0, fmt.Errorf("insert backfilled point %s: %v", point.Time.Format(time.RFC3339), err)
+		}
+		if affected, err := res.RowsAffected(); err == nil && affected == 1 {
+			inserted++
+		}
+	}
+	return inserted, tx.Commit()
+}
+
+func (s *Store) saveDailyEnergy(stationID string, days []DailyEnergy, source string) error {
+	if len(days) == 0 {
+		return nil
+	}
+	if err := s.ensureSchema(); err != nil {
+		return err
+	}
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	stmt, err := tx.Prepare(`insert into daily_energy (station_id, day, energy, source) values (?, ?, ?, ?)
+		on duplicate key update energy = values(energy), source = values(source)`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+	for _, day := range days {
+		if _, err := stmt.Exec(stationID, day.Day.Format(dateLayout), day.Energy, source); err != nil {
+			return fmt.Errorf("insert daily energy %s: %v", day.Day.Format(dateLayout), err)
+		}
+	}
+	return tx.Commit()
+}
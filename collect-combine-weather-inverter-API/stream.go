@@ -0,0 +1,291 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const streamHeartbeat = 30 * time.Second
+
+// Hub passes each snapshot the collector takes to the /stream clients. The
+// latest one is kept so new clients get it straight away.
+type Hub struct {
+	mu          sync.Mutex
+	subscribers map[*subscriber]bool
+	latest      map[string]interface{}
+	closed      bool
+}
+
+// subscriber receives snapshots in their v2 JSON shape. A client that falls
+// behind by more than the channel holds misses snapshots rather than holding
+// up the collector.
+type subscriber struct {
+	stations map[string]bool
+	fields   map[string]bool
+	ch       chan map[string]interface{}
+}
+
+func newHub() *Hub {
+	return &Hub{subscribers: make(map[*subscriber]bool)}
+}
+
+func (h *Hub) subscribe(stations, fields map[string]bool) *subscriber {
+	s := &subscriber{stations: stations, fields: fields, ch: make(chan map[string]interface{}, 4)}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.closed {
+		close(s.ch)
+		return s
+	}
+	h.subscribers[s] = true
+	if h.latest != nil && s.wants(h.latest) {
+		s.ch <- h.latest
+	}
+	return s
+}
+
+func (h *Hub) unsubscribe(s *subscriber) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.subscribers[s] {
+		delete(h.subscribers, s)
+		close(s.ch)
+	}
+}
+
+func (h *Hub) listeners() int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return len(h.subscribers)
+}
+
+func (h *Hub) publish(snapshot Snapshot) {
+	data, err := json.Marshal(snapshotV2(snapshot))
+	if err != nil {
+		logger.Error("encode stream snapshot", "err", err)
+		return
+	}
+	var event map[string]interface{}
+	json.Unmarshal(data, &event)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.latest = event
+	for s := range h.subscribers {
+		if !s.wants(event) {
+			continue
+		}
+		select {
+		case s.ch <- event:
+		default:
+			metrics.streamDropped.Add(1)
+		}
+	}
+}
+
+// close ends every stream, on shutdown.
+func (h *Hub) close() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.closed = true
+	for s := range h.subscribers {
+		delete(h.subscribers, s)
+		close(s.ch)
+	}
+}
+
+func (s *subscriber) wants(event map[string]interface{}) bool {
+	if len(s.stations) == 0 {
+		return true
+	}
+	station, _ := event["station_id"].(string)
+	return s.stations[station]
+}
+
+// filter keeps the requested fields. station_id, collected_at and each
+// inverter's serial_number are always sent so clients can tell updates
+// apart. Inverter fields are named inverters.<field> and weather fields
+// weather.<field>; naming inverters or weather keeps the whole object. An
+// inverter field may also be named on its own when no top-level field has
+// that name.
+func (s *subscriber) filter(event map[string]interface{}) map[string]interface{} {
+	if len(s.fields) == 0 {
+		return event
+	}
+	out := map[string]interface{}{
+		"station_id":   event["station_id"],
+		"collected_at": event["collected_at"],
+	}
+	var inverterFields, weatherFields []string
+	for field := range s.fields {
+		if name, ok := strings.CutPrefix(field, "weather."); ok {
+			weatherFields = append(weatherFields, name)
+		} else if name, ok := strings.CutPrefix(field, "inverters."); ok {
+			inverterFields = append(inverterFields, name)
+		} else if _, ok := event[field]; ok {
+			out[field] = event[field]
+		} else {
+			inverterFields = append(inverterFields, field)
+		}
+	}
+	if _, all := out["inverters"]; !all && len(inverterFields) > 0 {
+		inverters, _ := event["inverters"].([]interface{})
+		filtered := make([]interface{}, 0, len(inverters))
+		for _, inverter := range inverters {
+			inverter, _ := inverter.(map[string]interface{})
+			kept := map[string]interface{}{"serial_number": inverter["serial_number"]}
+			for _, field := range inverterFields {
+				kept[field] = inverter[field]
+			}
+			filtered = append(filtered, kept)
+		}
+		out["inverters"] = filtered
+	}
+	if _, all := out["weather"]; !all && len(weatherFields) > 0 {
+		weather, _ := event["weather"].(map[string]interface{})
+		kept := make(map[string]interface{})
+		for _, field := range weatherFields {
+			kept[field] = weather[field]
+		}
+		out["weather"] = kept
+	}
+	return out
+}
+
+// streamFields lists the names the fields parameter accepts.
+func streamFields() map[string]bool {
+	fields := make(map[string]bool)
+	add := func(t reflect.Type, prefix string) {
+		for i := 0; i < t.NumField(); i++ {
+			name, _, _ := strings.Cut(t.Field(i).Tag.Get("json"), ",")
+			fields[prefix+name] = true
+		}
+	}
+	add(reflect.TypeOf(SnapshotV2{}), "")
+	add(reflect.TypeOf(InverterV2{}), "inverters.")
+	add(reflect.TypeOf(InverterV2{}), "")
+	add(reflect.TypeOf(WeatherV2{}), "weather.")
+	return fields
+}
+
+func splitSet(value string) map[string]bool {
+	set := make(map[string]bool)
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			set[item] = true
+		}
+	}
+	return set
+}
+
+var upgrader = websocket.Upgrader{}
+
+func streamHandler(hub *Hub) http.HandlerFunc {
+	known := streamFields()
+	return func(w http.ResponseWriter, r *http.Request) {
+		stations := splitSet(r.URL.Query().Get("station"))
+		fields := splitSet(r.URL.Query().Get("fields"))
+		for field := range fields {
+			if !known[field] {
+				http.Error(w, "unknown field "+field, http.StatusBadRequest)
+				return
+			}
+		}
+		if websocket.IsWebSocketUpgrade(r) {
+			streamWebSocket(w, r, hub, stations, fields)
+			return
+		}
+		streamSSE(w, r, hub, stations, fields)
+	}
+}
+
+func streamSSE(w http.ResponseWriter, r *http.Request, hub *Hub, stations, fields map[string]bool) {
+	// The server's write timeout is meant for ordinary requests.
+	rc := http.NewResponseController(w)
+	rc.SetWriteDeadline(time.Time{})
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	rc.Flush()
+
+	sub := hub.subscribe(stations, fields)
+	defer hub.unsubscribe(sub)
+	heartbeat := time.NewTicker(streamHeartbeat)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-heartbeat.C:
+			fmt.Fprint(w, ": heartbeat\n\n")
+		case event, ok := <-sub.ch:
+			if !ok {
+				return
+			}
+			data, err := json.Marshal(sub.filter(event))
+			if err != nil {
+				return
+			}
+			fmt.Fprintf(w, "event: snapshot\ndata: %s\n\n", data)
+		}
+		if err := rc.Flush(); err != nil {
+			return
+		}
+	}
+}
+
+func streamWebSocket(w http.ResponseWriter, r *http.Request, hub *Hub, stations, fields map[string]bool) {
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	// Nothing is expected from the client; reading handles pongs and notices
+	// when it goes away.
+	gone := make(chan struct{})
+	conn.SetReadDeadline(time.Now().Add(2 * streamHeartbeat))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(2 * streamHeartbeat))
+	})
+	go func() {
+		defer close(gone)
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	sub := hub.subscribe(stations, fields)
+	defer hub.unsubscribe(sub)
+	heartbeat := time.NewTicker(streamHeartbeat)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-gone:
+			return
+		case <-heartbeat.C:
+			conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+			if err := conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				return
+			}
+		case event, ok := <-sub.ch:
+			conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+			if !ok {
+				conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseGoingAway, "shutting down"))
+				return
+			}
+			if err := conn.WriteJSON(sub.filter(event)); err != nil {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestSubscriberFilter(t *testing.T) {
+	var event map[string]interface{}
+	json.Unmarshal([]byte(`{"station_id": "s", "collected_at": "t", "capacity_kw": 10, "station_name": "home",
+		"inverters": [{"serial_number": "A", "capacity_kw": 5, "output_power_w": 1200}],
+		"weather": {"temperature_c": 21, "humidity_percent": 40}}`), &event)
+	tests := []struct {
+		fields string
+		want   string
+	}{
+		{"capacity_kw", `{"station_id": "s", "collected_at": "t", "capacity_kw": 10}`},
+		{"inverters.capacity_kw", `{"station_id": "s", "collected_at": "t", "inverters": [{"serial_number": "A", "capacity_kw": 5}]}`},
+		{"output_power_w", `{"station_id": "s", "collected_at": "t", "inverters": [{"serial_number": "A", "output_power_w": 1200}]}`},
+		{"weather.temperature_c", `{"station_id": "s", "collected_at": "t", "weather": {"temperature_c": 21}}`},
+		{"inverters,inverters.capacity_kw", `{"station_id": "s", "collected_at": "t",
+			"inverters": [{"serial_number": "A", "capacity_kw": 5, "output_power_w": 1200}]}`},
+	}
+	for _, test := range tests {
+		var want map[string]interface{}
+		json.Unmarshal([]byte(test.want), &want)
+		s := &subscriber{fields: splitSet(test.fields)}
+		// Round trip so the filtered slices compare like decoded JSON.
+		data, _ := json.Marshal(s.filter(event))
+		var got map[string]interface{}
+		json.Unmarshal(data, &got)
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("fields=%s: got %s, want %s", test.fields, data, test.want)
+		}
+	}
+}
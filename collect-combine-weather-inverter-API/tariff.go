@@ -0,0 +1,134 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// TariffConfig describes a retail electricity plan. Prices exclude tax and
+// are in currency units, not cents.
+type TariffConfig struct {
+	Currency          string          `json:"currency" doc:"Currency of the rates, e.g. AUD. Only used as a label."`
+	FeedInRate        float64         `json:"feedInRate" doc:"Credit per exported kWh."`
+	ImportRate        float64         `json:"importRate" doc:"Price per imported kWh outside the timeOfUse periods."`
+	TimeOfUse         []TimeOfUseRate `json:"timeOfUse" doc:"Import rates for parts of the day, in the station's local time, e.g. peak, shoulder and off-peak. The first matching period applies."`
+	DailySupplyCharge float64         `json:"dailySupplyCharge" doc:"Fixed charge per day of the billing period."`
+	TaxPercent        float64         `json:"taxPercent" doc:"Tax added to the bill total, e.g. 10 for GST."`
+	BillingDay        int             `json:"billingDay" doc:"Day of the month billing periods start, 1 to 28. 0: the 1st."`
+	BillingMonths     int             `json:"billingMonths" doc:"Length of a billing period in months, e.g. 3 for quarterly bills. 0: 1."`
+}
+
+type TimeOfUseRate struct {
+	Name string   `json:"name" doc:"Label in reports, e.g. peak."`
+	From string   `json:"from" doc:"Start, HH:MM local time."`
+	To   string   `json:"to" doc:"End, HH:MM, exclusive. May be earlier than from to cross midnight; 00:00 is the end of the day."`
+	Days []string `json:"days" doc:"mon, tue, wed, thu, fri, sat, sun, weekdays or weekends. Empty: every day."`
+	Rate float64  `json:"rate" doc:"Price per imported kWh."`
+}
+
+var weekdayNames = map[string][]time.Weekday{
+	"mon": {time.Monday}, "tue": {time.Tuesday}, "wed": {time.Wednesday}, "thu": {time.Thursday},
+	"fri": {time.Friday}, "sat": {time.Saturday}, "sun": {time.Sunday},
+	"weekdays": {time.Monday, time.Tuesday, time.Wednesday, time.Thursday, time.Friday},
+	"weekends": {time.Saturday, time.Sunday},
+}
+
+// parseClock turns HH:MM into minutes after midnight.
+func parseClock(value string) (int, error) {
+	t, err := time.Parse("15:04", value)
+	if err != nil {
+		return 0, fmt.Errorf("%q is not HH:MM", value)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+func (t TariffConfig) validate() []string {
+	var problems []string
+	if t.FeedInRate < 0 || t.ImportRate < 0 || t.DailySupplyCharge < 0 {
+		problems = append(problems, "tariff rates and charges must not be negative")
+	}
+	if t.TaxPercent < 0 || t.TaxPercent > 100 {
+		problems = append(problems, "tariff.taxPercent must be between 0 and 100")
+	}
+	if t.BillingDay < 0 || t.BillingDay > 28 {
+		problems = append(problems, "tariff.billingDay must be between 1 and 28")
+	}
+	if t.BillingMonths < 0 || t.BillingMonths > 12 {
+		problems = append(problems, "tariff.billingMonths must be between 1 and 12")
+	}
+	for i, period := range t.TimeOfUse {
+		field := fmt.Sprintf("tariff.timeOfUse[%d]", i)
+		if _, err := parseClock(period.From); err != nil {
+			problems = append(problems, fmt.Sprintf("%s.from: %v", field, err))
+		}
+		if _, err := parseClock(period.To); err != nil {
+			problems = append(problems, fmt.Sprintf("%s.to: %v", field, err))
+		}
+		for _, day := range period.Days {
+			if _, ok := weekdayNames[strings.ToLower(day)]; !ok {
+				problems = append(problems, fmt.Sprintf("%s.days: %q is not a day, weekdays or weekends", field, day))
+			}
+		}
+		if period.Rate < 0 {
+			problems = append(problems, field+".rate must not be negative")
+		}
+	}
+	return problems
+}
+
+func (p TimeOfUseRate) applies(local time.Time) bool {
+	if len(p.Days) > 0 {
+		match := false
+		for _, day := range p.Days {
+			for _, weekday := range weekdayNames[strings.ToLower(day)] {
+				match = match || weekday == local.Weekday()
+			}
+		}
+		if !match {
+			return false
+		}
+	}
+	from, _ := parseClock(p.From)
+	to, _ := parseClock(p.To)
+	minute := local.Hour()*60 + local.Minute()
+	if to <= from {
+		return minute >= from || minute < to
+	}
+	return minute >= from && minute < to
+}
+
+// defaultPeriod names the time covered by importRate in breakdowns.
+const defaultPeriod = "other"
+
+// importRate is the price of a kWh imported at local time, and the name of
+// the period it falls in.
+func (t TariffConfig) importRate(local time.Time) (float64, string) {
+	for i, period := range t.TimeOfUse {
+		if period.applies(local) {
+			return period.Rate, orDefault(period.Name, fmt.Sprintf("period %d", i+1))
+		}
+	}
+	return t.ImportRate, defaultPeriod
+}
+
+// billingPeriod returns the first and last local day of the billing period
+// containing day.
+func (t TariffConfig) billingPeriod(day time.Time) (time.Time, time.Time) {
+	startDay := t.BillingDay
+	if startDay == 0 {
+		startDay = 1
+	}
+	months := t.BillingMonths
+	if months == 0 {
+		months = 1
+	}
+	start := time.Date(day.Year(), day.Month(), startDay, 0, 0, 0, 0, day.Location())
+	if start.After(day) {
+		start = start.AddDate(0, -1, 0)
+	}
+	// Periods longer than a month are counted from January.
+	offset := (int(start.Month()) - 1) % months
+	start = start.AddDate(0, -offset, 0)
+	return start, start.AddDate(0, months, -1)
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBillingPeriod(t *testing.T) {
+	sydney, err := time.LoadLocation("Australia/Sydney")
+	if err != nil {
+		t.Fatal(err)
+	}
+	date := func(year int, month time.Month, day int) time.Time {
+		return time.Date(year, month, day, 0, 0, 0, 0, sydney)
+	}
+	tests := []struct {
+		name        string
+		tariff      TariffConfig
+		day         time.Time
+		first, last time.Time
+	}{
+		{"calendar month", TariffConfig{}, date(2026, 3, 15), date(2026, 3, 1), date(2026, 3, 31)},
+		{"first of the month", TariffConfig{}, date(2026, 2, 1), date(2026, 2, 1), date(2026, 2, 28)},
+		{"billing day after today", TariffConfig{BillingDay: 20}, date(2026, 3, 15), date(2026, 2, 20), date(2026, 3, 19)},
+		{"billing day before today", TariffConfig{BillingDay: 10}, date(2026, 3, 15), date(2026, 3, 10), date(2026, 4, 9)},
+		{"on the billing day", TariffConfig{BillingDay: 15}, date(2026, 3, 15).Add(18 * time.Hour), date(2026, 3, 15), date(2026, 4, 14)},
+		{"billing day after today in January", TariffConfig{BillingDay: 20}, date(2026, 1, 5), date(2025, 12, 20), date(2026, 1, 19)},
+		{"quarterly", TariffConfig{BillingMonths: 3}, date(2026, 5, 20), date(2026, 4, 1), date(2026, 6, 30)},
+		{"quarterly across the year", TariffConfig{BillingDay: 15, BillingMonths: 3}, date(2026, 1, 10), date(2025, 10, 15), date(2026, 1, 14)},
+		{"quarterly from January", TariffConfig{BillingDay: 15, BillingMonths: 3}, date(2026, 1, 20), date(2026, 1, 15), date(2026, 4, 14)},
+		{"quarterly in December", TariffConfig{BillingMonths: 3}, date(2025, 12, 31), date(2025, 10, 1), date(2025, 12, 31)},
+		{"yearly", TariffConfig{BillingMonths: 12}, date(2026, 7, 1), date(2026, 1, 1), date(2026, 12, 31)},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			first, last := test.tariff.billingPeriod(test.day)
+			if !first.Equal(test.first) || !last.Equal(test.last) {
+				t.Errorf("billingPeriod(%s) = %s to %s, want %s to %s", test.day.Format(dateLayout),
+					first.Format(dateLayout), last.Format(dateLayout), test.first.Format(dateLayout), test.last.Format(dateLayout))
+			}
+		})
+	}
+}
+
+func TestTimeOfUseRateApplies(t *testing.T) {
+	// 2026-03-02 is a Monday.
+	at := func(day int, hour int, minute int) time.Time {
+		return time.Date(2026, 3, day, hour, minute, 0, 0, time.UTC)
+	}
+	tests := []struct {
+		name   string
+		period TimeOfUseRate
+		local  time.Time
+		want   bool
+	}{
+		{"inside", TimeOfUseRate{From: "15:00", To: "21:00"}, at(2, 16, 30), true},
+		{"at the start", TimeOfUseRate{From: "15:00", To: "21:00"}, at(2, 15, 0), true},
+		{"at the end", TimeOfUseRate{From: "15:00", To: "21:00"}, at(2, 21, 0), false},
+		{"before", TimeOfUseRate{From: "15:00", To: "21:00"}, at(2, 14, 59), false},
+		{"across midnight, late", TimeOfUseRate{From: "22:00", To: "07:00"}, at(2, 23, 30), true},
+		{"across midnight, early", TimeOfUseRate{From: "22:00", To: "07:00"}, at(3, 6, 59), true},
+		{"across midnight, outside", TimeOfUseRate{From: "22:00", To: "07:00"}, at(3, 7, 0), false},
+		{"ending at 00:00", TimeOfUseRate{From: "21:00", To: "00:00"}, at(2, 23, 59), true},
+		{"ending at 00:00, after midnight", TimeOfUseRate{From: "21:00", To: "00:00"}, at(3, 0, 0), false},
+		{"weekdays on a Monday", TimeOfUseRate{From: "15:00", To: "21:00", Days: []string{"weekdays"}}, at(2, 16, 0), true},
+		{"weekdays on a Saturday", TimeOfUseRate{From: "15:00", To: "21:00", Days: []string{"weekdays"}}, at(7, 16, 0), false},
+		{"weekends on a Sunday", TimeOfUseRate{From: "00:00", To: "00:00", Days: []string{"weekends"}}, at(8, 12, 0), true},
+		{"weekends on a Friday", TimeOfUseRate{From: "00:00", To: "00:00", Days: []string{"weekends"}}, at(6, 12, 0), false},
+		{"listed days", TimeOfUseRate{From: "07:00", To: "09:00", Days: []string{"Mon", "wed"}}, at(4, 8, 0), true},
+		{"unlisted day", TimeOfUseRate{From: "07:00", To: "09:00", Days: []string{"mon", "wed"}}, at(3, 8, 0), false},
+	}
+	for _, test := range tests {
+		if got := test.period.applies(test.local); got != test.want {
+			t.Errorf("%s: applies(%s) = %v, want %v", test.name, test.local.Format("Mon 15:04"), got, test.want)
+		}
+	}
+}
+
+func TestImportRate(t *testing.T) {
+	tariff := TariffConfig{
+		ImportRate: 0.25,
+		TimeOfUse: []TimeOfUseRate{
+			{Name: "peak", From: "15:00", To: "21:00", Days: []string{"weekdays"}, Rate: 0.50},
+			{Name: "evening", From: "15:00", To: "23:00", Rate: 0.40},
+			{From: "00:00", To: "07:00", Rate: 0.15},
+		},
+	}
+	tests := []struct {
+		name     string
+		local    time.Time
+		rate     float64
+		category string
+	}{
+		{"first match wins", time.Date(2026, 3, 2, 16, 0, 0, 0, time.UTC), 0.50, "peak"},
+		{"later period when the first does not apply", time.Date(2026, 3, 7, 16, 0, 0, 0, time.UTC), 0.40, "evening"},
+		{"unnamed period", time.Date(2026, 3, 2, 3, 0, 0, 0, time.UTC), 0.15, "period 3"},
+		{"flat rate outside every period", time.Date(2026, 3, 2, 10, 0, 0, 0, time.UTC), 0.25, defaultPeriod},
+	}
+	for _, test := range tests {
+		rate, name := tariff.importRate(test.local)
+		if rate != test.rate || name != test.category {
+			t.Errorf("%s: importRate = %v, %q, want %v, %q", test.name, rate, name, test.rate, test.category)
+		}
+	}
+}
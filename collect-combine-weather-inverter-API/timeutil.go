@@ -0,0 +1,65 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"time"
+)
+
+const defaultSEMSDateFormat = "MM/dd/yyyy"
+
+// stationLocation resolves the timezone SEMS local times are reported in:
+// the configured IANA zone, then the weather provider's UTC offset, then the
+// offset between the station clock in SEMS Info and now.
+func stationLocation(config Config, weather Weather, inverterData InverterData, now time.Time) (*time.Location, error) {
+	if name := config.ClientConfig.Timezone; name != "" {
+		loc, err := time.LoadLocation(name)
+		if err != nil {
+			return nil, fmt.Errorf("clientConfig.timezone: %v", err)
+		}
+		return loc, nil
+	}
+	if weather.Timezone != 0 {
+		return time.FixedZone("", weather.Timezone), nil
+	}
+	info := inverterData.Data.Info
+	stationNow, err := time.Parse(semsTimeLayout(info.DateFormat), info.Time)
+	if err == nil {
+		offset := stationNow.Sub(now.UTC()).Round(15 * time.Minute)
+		if math.Abs(offset.Hours()) <= 14 {
+			return time.FixedZone("", int(offset.Seconds())), nil
+		}
+	}
+	return time.UTC, nil
+}
+
+// semsTimeLayout converts the .NET style date_format SEMS reports for a
+// station (e.g. "MM/dd/yyyy") into a Go layout with a time component.
+func semsTimeLayout(dateFormat string) string {
+	if dateFormat == "" {
+		dateFormat = defaultSEMSDateFormat
+	}
+	replacer := strings.NewReplacer("yyyy", "2006", "MM", "01", "dd", "02")
+	return replacer.Replace(dateFormat) + " 15:04:05"
+}
+
+// parseSEMSTime parses a SEMS local time string in the station timezone and
+// returns it in UTC. Empty or unparseable values return the zero time.
+func parseSEMSTime(value string, dateFormat string, loc *time.Location) time.Time {
+	if value == "" {
+		return time.Time{}
+	}
+	t, err := time.ParseInLocation(semsTimeLayout(dateFormat), value, loc)
+	if err != nil {
+		return time.Time{}
+	}
+	return t.UTC()
+}
+
+func unixToUTC(seconds int) time.Time {
+	if seconds == 0 {
+		return time.Time{}
+	}
+	return time.Unix(int64(seconds), 0).UTC()
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"time"
+)
+
+// serverTLSConfig returns nil when the server should speak plain HTTP.
+func serverTLSConfig(server ServerConfig) (*tls.Config, error) {
+	var cert tls.Certificate
+	var err error
+	switch {
+	case server.TLSSelfSigned:
+		cert, err = selfSignedCertificate(server.TLSCertFile, server.TLSKeyFile)
+	case server.TLSCertFile != "":
+		cert, err = tls.LoadX509KeyPair(server.TLSCertFile, server.TLSKeyFile)
+	default:
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("tls: %v", err)
+	}
+	return &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12}, nil
+}
+
+// selfSignedCertificate reuses the pair at certFile/keyFile when present and
+// otherwise generates one, saving it there when paths are given.
+func selfSignedCertificate(certFile string, keyFile string) (tls.Certificate, error) {
+	if certFile != "" {
+		if cert, err := tls.LoadX509KeyPair(certFile, keyFile); err == nil {
+			return cert, nil
+		} else if !os.IsNotExist(err) {
+			return tls.Certificate{}, err
+		}
+	}
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	template := x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: "collect-combine-weather-inverter-API"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().AddDate(5, 0, 0),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		DNSNames:              []string{"localhost"},
+		IPAddresses:           []net.IP{net.IPv4(127, 0, 0, 1), net.IPv6loopback},
+	}
+	if hostname, err := os.Hostname(); err == nil && hostname != "localhost" {
+		template.DNSNames = append(template.DNSNames, hostname)
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+	if certFile != "" {
+		if err := os.WriteFile(keyFile, keyPEM, 0600); err != nil {
+			return tls.Certificate{}, err
+		}
+		if err := os.WriteFile(certFile, certPEM, 0644); err != nil {
+			return tls.Certificate{}, err
+		}
+		logger.Info("generated self-signed certificate", "cert", certFile, "key", keyFile)
+	}
+	return tls.X509KeyPair(certPEM, keyPEM)
+}
@@ -0,0 +1,438 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Weather is the provider-neutral weather model every provider maps into.
+// Irradiance values are in W/m² and are zero when the provider has none.
+type Weather struct {
+	Provider     string
+	ObservedAt   int64
+	Temperature  float64
+	Humidity     float64
+	Pressure     float64
+	WindSpeed    float64
+	WindDeg      float64
+	CloudPercent int
+	Type         string
+	Description  string
+	Sunrise      int
+	Sunset       int
+	Timezone     int
+	GHI          float64
+	DNI          float64
+	DHI          float64
+}
+
+var weatherProviders = map[string]func(WeatherAPI) (Weather, error){
+	"openweathermap": getOpenWeatherMapData,
+	"openmeteo":      getOpenMeteoData,
+	"bom":            getBOMData,
+	"metno":          getMetNoData,
+}
+
+// getWeatherData falls back to the station coordinates reported by SEMS when
+// the config names no location of its own.
+func getWeatherData(config Config, stationLatitude float64, stationLongitude float64) (Weather, error) {
+	api := config.WeatherAPI
+	provider := strings.ToLower(api.Provider)
+	if provider == "" {
+		provider = "openweathermap"
+	}
+	fetch, ok := weatherProviders[provider]
+	if !ok {
+		return Weather{}, fmt.Errorf("unknown weather provider %q", api.Provider)
+	}
+	if !hasCoordinates(api) && (provider != "openweathermap" || api.CityID == "" && api.CityName == "" && api.ZipCode == "") {
+		api.Latitude = stationLatitude
+		api.Longitude = stationLongitude
+	}
+	weather, err := fetch(api)
+	if err != nil {
+		return Weather{}, fmt.Errorf("%s: %v", provider, err)
+	}
+	weather.Provider = provider
+	return weather, nil
+}
+
+func hasCoordinates(api WeatherAPI) bool {
+	return api.Latitude != 0 || api.Longitude != 0
+}
+
+func buildURL(baseURL string, query url.Values) (string, error) {
+	u, err := url.Parse(baseURL)
+	if err != nil {
+		return "", fmt.Errorf("invalid base URL %q: %v", baseURL, err)
+	}
+	q := u.Query()
+	for key, values := range query {
+		q[key] = values
+	}
+	u.RawQuery = q.Encode()
+	u.ForceQuery = false
+	return u.String(), nil
+}
+
+func formatCoordinate(v float64) string {
+	return strconv.FormatFloat(v, 'f', 4, 64)
+}
+
+func fetchJSON(requestURL string, userAgent string, v interface{}) error {
+	client := &http.Client{Timeout: 30 * time.Second}
+	req, err := http.NewRequest("GET", requestURL, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Add("Accept", "application/json")
+	if userAgent != "" {
+		req.Header.Add("User-Agent", userAgent)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: %s", requestURL, resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+func getOpenWeatherMapData(api WeatherAPI) (Weather, error) {
+	query := url.Values{"appid": {api.AppID}, "units": {"metric"}}
+	switch {
+	case hasCoordinates(api):
+		query.Set("lat", formatCoordinate(api.Latitude))
+		query.Set("lon", formatCoordinate(api.Longitude))
+	case api.CityID != "":
+		query.Set("id", api.CityID)
+	case api.CityName != "":
+		query.Set("q", joinNonEmpty(api.CityName, api.CountryCode))
+	case api.ZipCode != "":
+		query.Set("zip", joinNonEmpty(api.ZipCode, api.CountryCode))
+	default:
+		return Weather{}, fmt.Errorf("no location configured: set latitude/longitude, cityID, cityName or zipCode")
+	}
+	requestURL, err := buildURL(api.BaseURL, query)
+	if err != nil {
+		return Weather{}, err
+	}
+	var weatherData WeatherData
+	if err := fetchJSON(requestURL, api.UserAgent, &weatherData); err != nil {
+		return Weather{}, err
+	}
+	weather := Weather{
+		ObservedAt:   int64(weatherData.Dt),
+		Temperature:  weatherData.Main.Temp,
+		Humidity:     float64(weatherData.Main.Humidity),
+		Pressure:     float64(weatherData.Main.Pressure),
+		WindSpeed:    weatherData.Wind.Speed,
+		WindDeg:      float64(weatherData.Wind.Deg),
+		CloudPercent: weatherData.Clouds.All,
+		Sunrise:      weatherData.Sys.Sunrise,
+		Sunset:       weatherData.Sys.Sunset,
+		Timezone:     weatherData.Timezone,
+	}
+	if len(weatherData.Weather) > 0 {
+		weather.Type = weatherData.Weather[0].Main
+		weather.Description = weatherData.Weather[0].Description
+	}
+	return weather, nil
+}
+
+func getOpenMeteoData(api WeatherAPI) (Weather, error) {
+	baseURL := api.OpenMeteo.BaseURL
+	if baseURL == "" {
+		baseURL = "https://api.open-meteo.com/v1/forecast"
+	}
+	if !hasCoordinates(api) {
+		return Weather{}, fmt.Errorf("latitude/longitude required")
+	}
+	requestURL, err := buildURL(baseURL, url.Values{
+		"latitude":        {formatCoordinate(api.Latitude)},
+		"longitude":       {formatCoordinate(api.Longitude)},
+		"current":         {"temperature_2m,relative_humidity_2m,pressure_msl,cloud_cover,wind_speed_10m,wind_direction_10m,weather_code,shortwave_radiation,direct_normal_irradiance,diffuse_radiation"},
+		"daily":           {"sunrise,sunset"},
+		"forecast_days":   {"1"},
+		"timezone":        {"auto"},
+		"timeformat":      {"unixtime"},
+		"wind_speed_unit": {"ms"},
+	})
+	if err != nil {
+		return Weather{}, err
+	}
+	var data OpenMeteoData
+	if err := fetchJSON(requestURL, api.UserAgent, &data); err != nil {
+		return Weather{}, err
+	}
+	weatherType, description := wmoWeatherCode(data.Current.WeatherCode)
+	weather := Weather{
+		ObservedAt:   data.Current.Time,
+		Temperature:  data.Current.Temperature,
+		Humidity:     data.Current.Humidity,
+		Pressure:     data.Current.Pressure,
+		WindSpeed:    data.Current.WindSpeed,
+		WindDeg:      data.Current.WindDirection,
+		CloudPercent: int(data.Current.CloudCover),
+		Type:         weatherType,
+		Description:  description,
+		Timezone:     data.UTCOffsetSeconds,
+		GHI:          data.Current.ShortwaveRadiation,
+		DNI:          data.Current.DirectNormalIrradiance,
+		DHI:          data.Current.DiffuseRadiation,
+	}
+	if len(data.Daily.Sunrise) > 0 && len(data.Daily.Sunset) > 0 {
+		weather.Sunrise = int(data.Daily.Sunrise[0])
+		weather.Sunset = int(data.Daily.Sunset[0])
+	}
+	return weather, nil
+}
+
+func getBOMData(api WeatherAPI) (Weather, error) {
+	baseURL := api.BOM.BaseURL
+	if baseURL == "" {
+		baseURL = "http://www.bom.gov.au/fwo/"
+	}
+	if api.BOM.ProductID == "" || api.BOM.StationID == "" {
+		return Weather{}, fmt.Errorf("bom.productID and bom.stationID required")
+	}
+	product := url.PathEscape(api.BOM.ProductID)
+	requestURL := strings.TrimSuffix(baseURL, "/") + "/" + product + "/" + product + "." + url.PathEscape(api.BOM.StationID) + ".json"
+	var data BOMData
+	if err := fetchJSON(requestURL, userAgentOrDefault(api.UserAgent), &data); err != nil {
+		return Weather{}, err
+	}
+	if len(data.Observations.Data) == 0 {
+		return Weather{}, fmt.Errorf("no observations for station %s", api.BOM.StationID)
+	}
+	obs := data.Observations.Data[0]
+	weather := Weather{
+		Temperature: obs.AirTemp,
+		Humidity:    obs.RelHum,
+		Pressure:    obs.Press,
+		WindSpeed:   obs.WindSpdKmh / 3.6,
+		WindDeg:     compassDegrees(obs.WindDir),
+		Type:        "Clear",
+		Description: strings.ToLower(obs.Cloud),
+	}
+	if obs.CloudOktas != nil {
+		weather.CloudPercent = *obs.CloudOktas * 100 / 8
+		if *obs.CloudOktas > 0 {
+			weather.Type = "Clouds"
+		}
+	}
+	if obs.Weather != "" && obs.Weather != "-" {
+		weather.Description = strings.ToLower(obs.Weather)
+		weather.Type = weatherTypeFromText(obs.Weather, weather.Type)
+	}
+	utc, errUTC := time.Parse("20060102150405", obs.AifstimeUTC)
+	local, errLocal := time.Parse("20060102150405", obs.LocalDateTimeFull)
+	if errUTC == nil {
+		weather.ObservedAt = utc.Unix()
+		if errLocal == nil {
+			weather.Timezone = int(local.Sub(utc).Seconds())
+		}
+	}
+	return weather, nil
+}
+
+func getMetNoData(api WeatherAPI) (Weather, error) {
+	baseURL := api.MetNo.BaseURL
+	if baseURL == "" {
+		baseURL = "https://api.met.no/weatherapi/locationforecast/2.0/compact"
+	}
+	if !hasCoordinates(api) {
+		return Weather{}, fmt.Errorf("latitude/longitude required")
+	}
+	requestURL, err := buildURL(baseURL, url.Values{
+		"lat": {formatCoordinate(api.Latitude)},
+		"lon": {formatCoordinate(api.Longitude)},
+	})
+	if err != nil {
+		return Weather{}, err
+	}
+	var data MetNoData
+	if err := fetchJSON(requestURL, userAgentOrDefault(api.UserAgent), &data); err != nil {
+		return Weather{}, err
+	}
+	if len(data.Properties.Timeseries) == 0 {
+		return Weather{}, fmt.Errorf("empty timeseries")
+	}
+	current := data.Properties.Timeseries[0]
+	details := current.Data.Instant.Details
+	symbol := strings.SplitN(current.Data.Next1Hours.Summary.SymbolCode, "_", 2)[0]
+	weather := Weather{
+		Temperature:  details.AirTemperature,
+		Humidity:     details.RelativeHumidity,
+		Pressure:     details.AirPressureAtSeaLevel,
+		WindSpeed:    details.WindSpeed,
+		WindDeg:      details.WindFromDirection,
+		CloudPercent: int(details.CloudAreaFraction),
+		Type:         weatherTypeFromText(symbol, "Clouds"),
+		Description:  metNoDescription(symbol),
+	}
+	if symbol == "clearsky" {
+		weather.Type = "Clear"
+	}
+	if t, err := time.Parse(time.RFC3339, current.Time); err == nil {
+		weather.ObservedAt = t.Unix()
+	}
+	return weather, nil
+}
+
+func joinNonEmpty(parts ...string) string {
+	var kept []string
+	for _, p := range parts {
+		if p != "" {
+			kept = append(kept, p)
+		}
+	}
+	return strings.Join(kept, ",")
+}
+
+func userAgentOrDefault(userAgent string) string {
+	if userAgent != "" {
+		return userAgent
+	}
+	return "collect-solarandweather-mysql/1.0"
+}
+
+// wmoWeatherCode maps WMO 4677 present-weather codes used by Open-Meteo onto
+// the OpenWeatherMap main groups so stored weather types stay comparable.
+func wmoWeatherCode(code int) (string, string) {
+	switch {
+	case code == 0:
+		return "Clear", "clear sky"
+	case code == 1:
+		return "Clouds", "mainly clear"
+	case code == 2:
+		return "Clouds", "partly cloudy"
+	case code == 3:
+		return "Clouds", "overcast"
+	case code == 45 || code == 48:
+		return "Fog", "fog"
+	case code >= 51 && code <= 57:
+		return "Drizzle", "drizzle"
+	case code >= 61 && code <= 67, code >= 80 && code <= 82:
+		return "Rain", "rain"
+	case code >= 71 && code <= 77, code == 85 || code == 86:
+		return "Snow", "snow"
+	case code >= 95:
+		return "Thunderstorm", "thunderstorm"
+	}
+	return "Unknown", "weather code " + strconv.Itoa(code)
+}
+
+func weatherTypeFromText(text string, fallback string) string {
+	text = strings.ToLower(text)
+	switch {
+	case strings.Contains(text, "thunder") || strings.Contains(text, "storm"):
+		return "Thunderstorm"
+	case strings.Contains(text, "drizzle"):
+		return "Drizzle"
+	case strings.Contains(text, "snow") || strings.Contains(text, "sleet"):
+		return "Snow"
+	case strings.Contains(text, "rain") || strings.Contains(text, "shower"):
+		return "Rain"
+	case strings.Contains(text, "fog") || strings.Contains(text, "mist") || strings.Contains(text, "haze"):
+		return "Fog"
+	}
+	return fallback
+}
+
+func metNoDescription(symbol string) string {
+	replacer := strings.NewReplacer("clearsky", "clear sky", "partlycloudy", "partly cloudy",
+		"lightrain", "light rain", "heavyrain", "heavy rain", "showers", " showers", "andthunder", " and thunder")
+	return strings.TrimSpace(replacer.Replace(symbol))
+}
+
+func compassDegrees(dir string) float64 {
+	points := []string{"N", "NNE", "NE", "ENE", "E", "ESE", "SE", "SSE", "S", "SSW", "SW", "WSW", "W", "WNW", "NW", "NNW"}
+	for i, p := range points {
+		if p == dir {
+			return float64(i) * 22.5
+		}
+	}
+	return 0
+}
+
+type OpenMeteoData struct {
+	Latitude         float64 `json:"latitude"`
+	Longitude        float64 `json:"longitude"`
+	UTCOffsetSeconds int     `json:"utc_offset_seconds"`
+	Timezone         string  `json:"timezone"`
+	Current          struct {
+		Time                   int64   `json:"time"`
+		Temperature            float64 `json:"temperature_2m"`
+		Humidity               float64 `json:"relative_humidity_2m"`
+		Pressure               float64 `json:"pressure_msl"`
+		CloudCover             float64 `json:"cloud_cover"`
+		WindSpeed              float64 `json:"wind_speed_10m"`
+		WindDirection          float64 `json:"wind_direction_10m"`
+		WeatherCode            int     `json:"weather_code"`
+		ShortwaveRadiation     float64 `json:"shortwave_radiation"`
+		DirectNormalIrradiance float64 `json:"direct_normal_irradiance"`
+		DiffuseRadiation       float64 `json:"diffuse_radiation"`
+	} `json:"current"`
+	Daily struct {
+		Sunrise []int64 `json:"sunrise"`
+		Sunset  []int64 `json:"sunset"`
+	} `json:"daily"`
+}
+
+type BOMData struct {
+	Observations struct {
+		Header []struct {
+			Name     string `json:"name"`
+			TimeZone string `json:"time_zone"`
+		} `json:"header"`
+		Data []struct {
+			Wmo               int     `json:"wmo"`
+			Name              string  `json:"name"`
+			LocalDateTimeFull string  `json:"local_date_time_full"`
+			AifstimeUTC       string  `json:"aifstime_utc"`
+			Lat               float64 `json:"lat"`
+			Lon               float64 `json:"lon"`
+			AirTemp           float64 `json:"air_temp"`
+			Cloud             string  `json:"cloud"`
+			CloudOktas        *int    `json:"cloud_oktas"`
+			Press             float64 `json:"press"`
+			RelHum            float64 `json:"rel_hum"`
+			Weather           string  `json:"weather"`
+			WindDir           string  `json:"wind_dir"`
+			WindSpdKmh        float64 `json:"wind_spd_kmh"`
+		} `json:"data"`
+	} `json:"observations"`
+}
+
+type MetNoData struct {
+	Properties struct {
+		Timeseries []struct {
+			Time string `json:"time"`
+			Data struct {
+				Instant struct {
+					Details struct {
+						AirPressureAtSeaLevel float64 `json:"air_pressure_at_sea_level"`
+						AirTemperature        float64 `json:"air_temperature"`
+						CloudAreaFraction     float64 `json:"cloud_area_fraction"`
+						RelativeHumidity      float64 `json:"relative_humidity"`
+						WindFromDirection     float64 `json:"wind_from_direction"`
+						WindSpeed             float64 `json:"wind_speed"`
+					} `json:"details"`
+				} `json:"instant"`
+				Next1Hours struct {
+					Summary struct {
+						SymbolCode string `json:"symbol_code"`
+					} `json:"summary"`
+				} `json:"next_1_hours"`
+			} `json:"data"`
+		} `json:"timeseries"`
+	} `json:"properties"`
+}
@@ -0,0 +1,96 @@
+"use strict";
+
+// Live values come from /stream, the day curve from /v2/power-curve. Paths
+// are relative so the dashboard also works behind a reverse proxy prefix.
+
+const $ = (id) => document.getElementById(id);
+let capacityW = 0;
+let curve = [];
+
+function midnight() {
+  const d = new Date();
+  d.setHours(0, 0, 0, 0);
+  return d;
+}
+
+function fixed(value, digits) {
+  return value == null ? "–" : value.toFixed(digits);
+}
+
+function showSnapshot(s) {
+  const inverters = s.inverters || [];
+  const power = inverters.reduce((sum, i) => sum + i.output_power_w, 0);
+  const energy = inverters.reduce((sum, i) => sum + i.energy_today_kwh, 0);
+  capacityW = s.capacity_kw * 1000;
+
+  $("station").textContent = s.station_name || s.station_id;
+  $("updated").textContent = "updated " + new Date(s.collected_at).toLocaleTimeString();
+  $("power").textContent = fixed(power / 1000, 2);
+  $("energy").textContent = fixed(energy, 1);
+  $("battery-tile").hidden = s.battery_soc_percent == null;
+  $("soc").textContent = fixed(s.battery_soc_percent, 0);
+  $("temperature").textContent = fixed(s.weather.temperature_c, 1);
+  $("condition").textContent = s.weather.description || s.weather.condition || "";
+
+  const t = new Date(s.collected_at);
+  if (t >= midnight()) {
+    curve.push({ time: t, power: power });
+    drawCurve();
+  }
+}
+
+function drawCurve() {
+  const svg = $("curve");
+  const start = midnight().getTime();
+  const span = 24 * 3600 * 1000;
+  const top = Math.max(capacityW, ...curve.map((p) => p.power), 1);
+  const points = curve
+    .filter((p) => p.time.getTime() >= start)
+    .map((p) => {
+      const x = ((p.time.getTime() - start) / span) * 1000;
+      const y = 300 - (p.power / top) * 300;
+      return x.toFixed(1) + "," + y.toFixed(1);
+    });
+  let grid = "";
+  for (let h = 6; h < 24; h += 6) {
+    const x = (h / 24) * 1000;
+    grid += `<line x1="${x}" y1="0" x2="${x}" y2="300"/>`;
+  }
+  svg.innerHTML = grid + `<polyline points="${points.join(" ")}"/>`;
+}
+
+async function loadCurve() {
+  const from = midnight().toISOString();
+  try {
+    const res = await fetch("v2/power-curve?from=" + encodeURIComponent(from));
+    if (res.status === 401) {
+      $("curve-status").textContent = "sign in with one of auth.users; API keys cannot be used here";
+      return;
+    }
+    if (!res.ok) {
+      const body = await res.json().catch(() => ({}));
+      $("curve-status").textContent = body.error || "history unavailable (" + res.status + ")";
+      return;
+    }
+    const data = await res.json();
+    curve = data.points.map((p) => ({ time: new Date(p.time), power: p.output_power_w }));
+    $("curve-status").textContent = "";
+    drawCurve();
+  } catch (err) {
+    $("curve-status").textContent = "history unavailable: " + err;
+  }
+}
+
+function connect() {
+  const events = new EventSource("stream");
+  events.addEventListener("snapshot", (e) => showSnapshot(JSON.parse(e.data)));
+  events.onerror = () => {
+    $("updated").textContent = "connection lost, retrying…";
+  };
+}
+
+loadCurve();
+// Reload now and then to pick up the stored averages and to start a new
+// curve after midnight.
+setInterval(loadCurve, 15 * 60 * 1000);
+connect();
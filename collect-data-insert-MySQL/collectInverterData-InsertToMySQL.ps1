@@ -1,4 +1,5 @@
 ﻿function ConvertToMySQLDatetime($datetime){
+    # readtime and boottime are SEMS strings, sunrise and sunset Unix times
     try {
         return [datetime]::parseexact($datetime, 'MM/dd/yyyy HH:mm:ss', $null).ToString('yyyy-MM-dd HH:mm:ss')
     }catch{
@@ -8,19 +9,45 @@
 
 Add-Type -Path 'C:\Program Files (x86)\MySQL\Connector NET 8.0\Assemblies\v4.5.2\MySql.Data.dll'
 
+# API key with the "live" scope when the API has auth configured
+$apiKey = ''
+$headers = @{}
+if ($apiKey) {
+    $headers['X-API-Key'] = $apiKey
+}
+
+$connectionString = 'server=localhost;uid=;pwd=;database='
+
+# clear_sky_output, performance_ratio and specific_yield are only written once
+# inverter_data has them (see the README); older tables keep the original columns.
+$Connection = [MySql.Data.MySqlClient.MySqlConnection]@{ConnectionString=$connectionString}
+$Connection.Open()
+$sql = New-Object MySql.Data.MySqlClient.MySqlCommand
+$sql.Connection = $Connection
+$sql.CommandText = "select count(*) from information_schema.columns where table_schema = database() and table_name = 'inverter_data' and column_name in ('clear_sky_output', 'performance_ratio', 'specific_yield')"
+$hasPerformanceColumns = [int]$sql.ExecuteScalar() -eq 3
+$Connection.Close()
+if (-not $hasPerformanceColumns) {
+    Write-Warning 'inverter_data has no clear_sky_output, performance_ratio and specific_yield columns; they will not be stored'
+}
 
 while ($true){
-    $response = Invoke-RestMethod -Method "GET" -Uri "http://API/getinverterdata" 
+    $response = Invoke-RestMethod -Method "GET" -Uri "http://API/getinverterdata" -Headers $headers
 
     $response.readtime = ConvertToMySQLDatetime $response.readtime
     $response.boottime = ConvertToMySQLDatetime $response.boottime
     $response.sunrise = ConvertToMySQLDatetime $response.sunrise
     $response.sunset = ConvertToMySQLDatetime $response.sunset
 
-    $sqlQuery = "insert into inverter_data (inverter_name, inverter_capacity, inverter_current, inverter_day_total, inverter_month_total, inverter_total, read_time, boot_time, current_temp, cloud_percent, weather, weather_description, sunrise, sunset) values ("
-    $sqlQuery += "`"$($response.name)`", $($response.capacity), $($response.currentoutput), $($response.dayoutput), $($response.monthOutput), $($response.totaloutput), `"$($response.readtime)`", `"$($response.boottime)`", $($response.currenttemp), $($response.cloudpercent), `"$($response.weather)`", `"$($response.weatherdesc)`", `"$($response.sunrise)`", `"$($response.sunset)`")"
+    $columns = "inverter_name, inverter_capacity, inverter_current, inverter_day_total, inverter_month_total, inverter_total, read_time, boot_time, current_temp, cloud_percent, weather, weather_description, sunrise, sunset"
+    $values = "`"$($response.name)`", $($response.capacity), $($response.currentoutput), $($response.dayoutput), $($response.monthOutput), $($response.totaloutput), `"$($response.readtime)`", `"$($response.boottime)`", $($response.currenttemp), $($response.cloudpercent), `"$($response.weather)`", `"$($response.weatherdesc)`", `"$($response.sunrise)`", `"$($response.sunset)`""
+    if ($hasPerformanceColumns) {
+        $columns += ", clear_sky_output, performance_ratio, specific_yield"
+        $values += ", $($response.clearskyoutput), $($response.performanceratio), $($response.specificyield)"
+    }
+    $sqlQuery = "insert into inverter_data ($columns) values ($values)"
 
-    $Connection = [MySql.Data.MySqlClient.MySqlConnection]@{ConnectionString='server=localhost;uid=;pwd=;database='}
+    $Connection = [MySql.Data.MySqlClient.MySqlConnection]@{ConnectionString=$connectionString}
     $Connection.Open()
  
     # Define a MySQL Command Object for a non-query.